@@ -16,13 +16,25 @@ have a configurable lease set and are automatically revoked at the
 end of the lease.
 
 After mounting this backend, credentials to generate Fauna keys must
-be configured with the "root" path and policies must be written using
+be configured with the "root" path, or with named connections under
+"config/connections/", and policies must be written using
 the "roles/" endpoints before any keys can be generated.
+
+Long-lived keys that Vault rotates on a schedule can be managed with the
+"static-roles/" endpoints and read from "static-creds/".
+
+Vault can also act as a JWT issuer for Fauna access providers, configured
+at "config/jwt". Its signing keys are published at "jwks".
 `
 
 const (
-	rootConfigPath    = "config/root"
-	minKeyRollbackAge = 1 * time.Second
+	rootConfigPath         = "config/root"
+	connectionConfigPrefix = "config/connections/"
+
+	// minKeyRollbackAge leaves WAL entries alone long enough for the
+	// issuance that wrote them to finish, including every schema statement
+	// of a new database.
+	minKeyRollbackAge = 5 * time.Minute
 )
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -35,32 +47,53 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 
 func Backend() *backend {
 	var b backend
+	b.faunaClients = make(map[string]*FaunaClient)
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
 
 		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				jwksPath,
+				discoveryPath,
+			},
 			LocalStorage: []string{
 				framework.WALPrefix,
 			},
 			SealWrapStorage: []string{
-				"config/root",
+				rootConfigPath,
+				connectionConfigPrefix,
+				"static-role/",
+				jwtKeysPath,
 			},
 		},
 
 		Paths: []*framework.Path{
 			pathConfigRoot(&b),
 			pathConfigRotateRoot(&b),
+			pathConfigConnections(&b),
+			pathListConfigConnections(&b),
+			pathConfigRotateConnection(&b),
 			pathConfigLease(&b),
+			pathConfigJWT(&b),
+			pathConfigRotateJWT(&b),
+			pathJWKS(&b),
+			pathDiscovery(&b),
+			pathAccessProviders(&b),
 			pathRoles(&b),
 			pathListRoles(&b),
+			pathStaticRoles(&b),
+			pathListStaticRoles(&b),
+			pathStaticCreds(&b),
 			pathKey(&b),
 		},
 
 		Secrets: []*framework.Secret{
 			faunaKeys(&b),
+			faunaTokens(&b),
 		},
 
 		Invalidate:        b.invalidate,
+		PeriodicFunc:      b.periodicFunc,
 		WALRollback:       b.walRollback,
 		WALRollbackMinAge: minKeyRollbackAge,
 		BackendType:       logical.TypeLogical,
@@ -78,32 +111,37 @@ type backend struct {
 	// Mutex to protect access to fauna clients and client configs
 	clientMutex sync.RWMutex
 
-	// client hold configured Fauna client for reuse, and
-	// to enable mocking with Fauna iface for tests
-	faunaClient *FaunaClient
+	// Mutex to protect access to the JWT issuer config and signing keys
+	jwtMutex sync.RWMutex
+
+	// faunaClients holds configured Fauna clients for reuse, keyed by
+	// connection name. The connection configured at config/root is "".
+	faunaClients map[string]*FaunaClient
 }
 
 func (b *backend) invalidate(ctx context.Context, key string) {
 	switch {
 	case key == rootConfigPath:
-		b.clearClient()
+		b.clearClient("")
+	case strings.HasPrefix(key, connectionConfigPrefix):
+		b.clearClient(strings.TrimPrefix(key, connectionConfigPrefix))
 	}
 }
 
-// clearClient clears the backend's Fauna client
-func (b *backend) clearClient() {
+// clearClient clears the backend's Fauna client for a connection
+func (b *backend) clearClient(connection string) {
 	b.clientMutex.Lock()
 	defer b.clientMutex.Unlock()
-	b.faunaClient = nil
+	delete(b.faunaClients, connection)
 }
 
-// clientFauna returns the configured Fauna client. If nil, it constructs a new one
-// and returns it, setting it the internal variable
-func (b *backend) client(ctx context.Context, s logical.Storage) (*FaunaClient, error) {
+// client returns the configured Fauna client for a connection. If there is
+// none, it constructs a new one and caches it
+func (b *backend) client(ctx context.Context, s logical.Storage, connection string) (*FaunaClient, error) {
 	b.clientMutex.RLock()
-	if b.faunaClient != nil {
+	if client, ok := b.faunaClients[connection]; ok {
 		b.clientMutex.RUnlock()
-		return b.faunaClient, nil
+		return client, nil
 	}
 
 	// Upgrade the lock for writing
@@ -113,15 +151,24 @@ func (b *backend) client(ctx context.Context, s logical.Storage) (*FaunaClient,
 
 	// check client again, in the event that a client was being created while we
 	// waited for Lock()
-	if b.faunaClient != nil {
-		return b.faunaClient, nil
+	if client, ok := b.faunaClients[connection]; ok {
+		return client, nil
 	}
 
-	client, err := nonCachedClient(ctx, s, b.Logger())
+	client, err := nonCachedClient(ctx, s, connection, b.Logger())
 	if err != nil {
 		return nil, err
 	}
-	b.faunaClient = client
+	b.faunaClients[connection] = client
+
+	return client, nil
+}
 
-	return b.faunaClient, nil
+// connectionConfigPath returns the storage key of a connection's config.
+// The unnamed connection is the one configured at config/root.
+func connectionConfigPath(connection string) string {
+	if connection == "" {
+		return rootConfigPath
+	}
+	return connectionConfigPrefix + connection
 }
@@ -2,26 +2,153 @@ package fauna
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
+	"time"
 
 	f "github.com/fauna/faunadb-go/v5/faunadb"
-	"github.com/hashicorp/errwrap"
 	cleanhttp "github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 type FaunaKey struct {
+	Secret       string  `fauna:"secret"`
+	HashedSecret string  `fauna:"hashed_secret"`
+	Ref          f.RefV  `fauna:"ref"`
+	Role         f.Value `fauna:"role"`
+	Database     f.RefV  `fauna:"database"`
+	Data         f.Value `fauna:"data"`
+	TS           int64   `fauna:"ts"`
+}
+
+type FaunaToken struct {
 	Secret   string  `fauna:"secret"`
 	Ref      f.RefV  `fauna:"ref"`
-	Role     f.Value `fauna:"role"`
-	Database f.RefV  `fauna:"database"`
+	Instance f.RefV  `fauna:"instance"`
+	Data     f.Value `fauna:"data"`
+	TS       int64   `fauna:"ts"`
+}
+
+// roleName returns the role of the key in the form used by role entries:
+// the name of a built-in role, or "roles/<name>" for a user-defined role.
+func (k *FaunaKey) roleName() string {
+	switch role := k.Role.(type) {
+	case f.StringV:
+		return string(role)
+	case f.RefV:
+		return "roles/" + role.ID
+	case *f.RefV:
+		return "roles/" + role.ID
+	}
+	return ""
 }
 
+const (
+	defaultEndpoint = "https://db.fauna.com"
+
+	// faunaAPIVersion matches the version the driver sends, so raw queries
+	// behave the same as those built with it
+	faunaAPIVersion = "5"
+)
+
 type FaunaClient struct {
-	client *f.FaunaClient
-	logger hclog.Logger
+	client     *f.FaunaClient
+	httpClient *http.Client
+	endpoint   string
+	secret     string
+	scope      string
+	apiVersion string
+	logger     hclog.Logger
+
+	// connectionType and regionGroup are only used by account connections
+	connectionType string
+	regionGroup    string
+}
+
+// scoped returns a client acting as admin within database, a path relative
+// to the client's own database. An empty path returns fc itself.
+func (fc *FaunaClient) scoped(database string) *FaunaClient {
+	if database == "" {
+		return fc
+	}
+
+	scope := database
+	if fc.scope != "" {
+		scope = fc.scope + "/" + database
+	}
+
+	return &FaunaClient{
+		client:     fc.client.NewSessionClient(scopedSecret(fc.secret, scope, "admin")),
+		httpClient: fc.httpClient,
+		endpoint:   fc.endpoint,
+		secret:     fc.secret,
+		scope:      scope,
+		apiVersion: fc.apiVersion,
+		logger:     fc.logger,
+
+		connectionType: fc.connectionType,
+		regionGroup:    fc.regionGroup,
+	}
+}
+
+// scopedSecret derives a secret that acts as role within database, a path
+// relative to the database of secret's key. role is a built-in role, or
+// "roles/<name>" for a user-defined role in that database.
+func scopedSecret(secret, database, role string) string {
+	if strings.HasPrefix(role, "roles/") {
+		role = "@role/" + strings.TrimPrefix(role, "roles/")
+	}
+	return secret + ":" + database + ":" + role
+}
+
+// queryRaw runs a query given in Fauna's JSON wire format. The driver can
+// only send queries it built itself, so this talks to the endpoint directly.
+func (fc *FaunaClient) queryRaw(query string) error {
+	if !json.Valid([]byte(query)) {
+		return fmt.Errorf("query is not valid JSON")
+	}
+
+	secret := fc.secret
+	if fc.scope != "" {
+		secret = scopedSecret(fc.secret, fc.scope, "admin")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fc.endpoint, strings.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-FaunaDB-API-Version", faunaAPIVersion)
+
+	resp, err := fc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	var res struct {
+		Errors []struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || len(res.Errors) == 0 {
+		return fmt.Errorf("unexpected response from Fauna: %s", resp.Status)
+	}
+	return fmt.Errorf("%s: %s", res.Errors[0].Code, res.Errors[0].Description)
 }
 
 func (fc *FaunaClient) strToRef(refStr string) (*f.RefV, error) {
@@ -30,8 +157,13 @@ func (fc *FaunaClient) strToRef(refStr string) (*f.RefV, error) {
 		return nil, err
 	}
 
-	ref := value.(f.RefV)
-	return &ref, nil
+	switch ref := value.(type) {
+	case *f.RefV:
+		return ref, nil
+	case f.RefV:
+		return &ref, nil
+	}
+	return nil, fmt.Errorf("%q is not a Fauna ref", refStr)
 }
 
 func (fc *FaunaClient) deleteKey(ref f.RefV) error {
@@ -39,21 +171,126 @@ func (fc *FaunaClient) deleteKey(ref f.RefV) error {
 	return err
 }
 
+// deleteKeyByRef deletes the key with the given JSON encoded ref. Keys that
+// no longer exist, e.g. because they were deleted by hand, are ignored.
+func (fc *FaunaClient) deleteKeyByRef(refStr string) error {
+	ref, err := fc.strToRef(refStr)
+	if err != nil {
+		return err
+	}
+
+	err = fc.deleteKey(*ref)
+	if _, ok := err.(f.InstanceNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// createDatabase creates a child database called name and returns its ref.
+func (fc *FaunaClient) createDatabase(name string) (*f.RefV, error) {
+	res, err := fc.client.Query(f.CreateDatabase(f.Obj{"name": name}))
+	if err != nil {
+		return nil, err
+	}
+
+	var ref f.RefV
+	if err := res.At(f.ObjKey("ref")).Get(&ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// joinDatabasePath joins two database paths, either of which may be empty.
+func joinDatabasePath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	}
+	return parent + "/" + child
+}
+
+// splitDatabasePath splits a slash separated path of nested databases, such
+// as "org/team/app", into the path of its parent and its name.
+func splitDatabasePath(path string) (string, string) {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
+
+// deleteDatabaseByRef deletes the database with the given JSON encoded ref,
+// along with everything in it. Databases that no longer exist are ignored.
+func (fc *FaunaClient) deleteDatabaseByRef(refStr string) error {
+	ref, err := fc.strToRef(refStr)
+	if err != nil {
+		return err
+	}
+
+	_, err = fc.client.Query(f.If(f.Exists(*ref), f.Delete(*ref), f.Null()))
+	return err
+}
+
+// createRole creates a user-defined role called name. privileges and
+// membership are given in Fauna's JSON wire format, as the driver has no way
+// to send them as is; membership may be empty.
+func (fc *FaunaClient) createRole(name, privileges, membership string) error {
+	params := map[string]any{
+		"name":       name,
+		"privileges": json.RawMessage(privileges),
+	}
+	if membership != "" {
+		params["membership"] = json.RawMessage(membership)
+	}
+
+	query, err := json.Marshal(map[string]any{
+		"create_role": map[string]any{"object": params},
+	})
+	if err != nil {
+		return err
+	}
+	return fc.queryRaw(string(query))
+}
+
+// deleteRoleByName deletes the user-defined role called name. Roles that no
+// longer exist are ignored.
+func (fc *FaunaClient) deleteRoleByName(name string) error {
+	_, err := fc.client.Query(f.If(f.Exists(f.Role(name)), f.Delete(f.Role(name)), f.Null()))
+	return err
+}
+
+// deleteKeyBySecret deletes the key secret belongs to, which also reaches a
+// key living in the parent of the client's database. Keys that no longer
+// exist are ignored.
 func (fc *FaunaClient) deleteKeyBySecret(secret string) error {
 	query := f.Delete(f.Select("ref", f.KeyFromSecret(secret)))
 	_, err := fc.client.Query(query)
+	if _, ok := err.(f.InstanceNotFoundError); ok {
+		return nil
+	}
 	return err
 }
 
-func (fc *FaunaClient) createKey(role *FaunaRoleEntry) (*FaunaKey, error) {
+// createKey creates a key for role. Fauna only creates keys for child
+// databases, so keys for a database are created in its parent, and the
+// returned key's ref is relative to the returned parent path. Unless expires
+// is zero, it is also the key's ttl, after which Fauna deletes the key by
+// itself.
+func (fc *FaunaClient) createKey(role *FaunaRoleEntry, expires time.Time) (*FaunaKey, string, error) {
 	create := f.Obj{}
 
+	var parent, name string
 	if role.Database != "" {
-		create["database"] = f.Database(role.Database)
+		parent, name = splitDatabasePath(role.Database)
+		create["database"] = f.Database(name)
 	}
 
+	// User-defined roles of keys for a database are defined in it
 	roleTokens := strings.Split(role.Role, "/")
-	if len(roleTokens) == 2 {
+	if len(roleTokens) == 2 && name != "" {
+		create["role"] = f.ScopedRole(roleTokens[1], f.Database(name))
+	} else if len(roleTokens) == 2 {
 		create["role"] = f.Role(roleTokens[1])
 	} else {
 		create["role"] = roleTokens[0]
@@ -63,7 +300,139 @@ func (fc *FaunaClient) createKey(role *FaunaRoleEntry) (*FaunaKey, error) {
 		create["data"] = role.Extra
 	}
 
-	res, err := fc.client.Query(f.CreateKey(create))
+	if !expires.IsZero() {
+		create["ttl"] = faunaTime(expires)
+	}
+
+	faunaKey, err := fc.scoped(parent).queryKey(f.CreateKey(create))
+	if err != nil {
+		return nil, "", err
+	}
+	return faunaKey, parent, nil
+}
+
+// setKeyTTL replaces the ttl of the key with the given JSON encoded ref.
+func (fc *FaunaClient) setKeyTTL(refStr string, expires time.Time) error {
+	ref, err := fc.strToRef(refStr)
+	if err != nil {
+		return err
+	}
+	_, err = fc.client.Query(f.Update(*ref, f.Obj{"ttl": faunaTime(expires)}))
+	return err
+}
+
+// faunaTime converts t to a Fauna timestamp.
+func faunaTime(t time.Time) f.Expr {
+	return f.Time(t.UTC().Format(time.RFC3339Nano))
+}
+
+// createToken issues a token for the document a role of type "token" names,
+// either by its id or as the first match of an index lookup. For roles that
+// map Vault entities, entity holds the entity's data: its document is looked
+// up through the role's entity index by the entity ID, updated with the data
+// or created with it, and the token is issued for it in the same query.
+func (fc *FaunaClient) createToken(role *FaunaRoleEntry, entityID string, entity map[string]any) (*FaunaToken, error) {
+	var instance f.Expr
+	if role.EntityIndex != "" {
+		match := f.MatchTerm(f.Index(role.EntityIndex), entityID)
+		instance = f.If(f.Exists(match),
+			f.Select("ref", f.Update(f.Select("ref", f.Get(match)), f.Obj{"data": entity})),
+			f.Select("ref", f.Create(f.Collection(role.Collection), f.Obj{"data": entity})))
+	} else if role.DocumentID != "" {
+		instance = f.Ref(f.Collection(role.Collection), role.DocumentID)
+	} else {
+		terms := make(f.Arr, len(role.IndexTerms))
+		for i, term := range role.IndexTerms {
+			terms[i] = term
+		}
+		instance = f.Select("ref", f.Get(f.MatchTerm(f.Index(role.Index), terms)))
+	}
+
+	create := f.Obj{"instance": instance}
+	if role.Extra != nil {
+		create["data"] = role.Extra
+	}
+
+	res, err := fc.client.Query(f.Create(f.Tokens(), create))
+	if err != nil {
+		return nil, err
+	}
+
+	var token FaunaToken
+	if err := res.Get(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// upsertAccessProvider creates the named access provider, or updates it to
+// trust issuer, and returns the audience JWTs for it must carry. Roles are
+// the names of the user-defined roles it grants, with or without "roles/".
+func (fc *FaunaClient) upsertAccessProvider(name, issuer, jwksURI string, roles []string) (string, error) {
+	providerRoles := make(f.Arr, len(roles))
+	for i, role := range roles {
+		providerRoles[i] = f.Role(strings.TrimPrefix(role, "roles/"))
+	}
+
+	params := f.Obj{
+		"issuer":   issuer,
+		"jwks_uri": jwksURI,
+		"roles":    providerRoles,
+	}
+	create := f.Obj{"name": name}
+	for k, v := range params {
+		create[k] = v
+	}
+
+	res, err := fc.client.Query(f.If(f.Exists(f.AccessProvider(name)),
+		f.Update(f.AccessProvider(name), params),
+		f.CreateAccessProvider(create)))
+	if err != nil {
+		return "", err
+	}
+
+	var audience string
+	if err := res.At(f.ObjKey("audience")).Get(&audience); err != nil {
+		return "", err
+	}
+	return audience, nil
+}
+
+// copyKey creates a new key with the same role and data as key, the client's
+// own key. A key scoped to a child database acts inside it and can't reach
+// the database it lives in, so the copy is made where the key acts, which
+// gives it the same access without a database of its own.
+func (fc *FaunaClient) copyKey(key *FaunaKey) (*FaunaKey, error) {
+	create := f.Obj{"role": key.Role}
+
+	if key.Data != nil {
+		create["data"] = key.Data
+	}
+
+	return fc.queryKey(f.CreateKey(create))
+}
+
+// keyFromSecret returns the key document the given secret belongs to.
+func (fc *FaunaClient) keyFromSecret(secret string) (*FaunaKey, error) {
+	return fc.queryKey(f.KeyFromSecret(secret))
+}
+
+// verifySecret checks that Fauna accepts secret by running a trivial query
+// with it.
+func (fc *FaunaClient) verifySecret(secret string) error {
+	_, err := fc.client.NewSessionClient(secret).Query(f.Now())
+	return err
+}
+
+// verifyKeyAccess checks that the client's secret is accepted by Fauna and
+// may manage keys in its database, without changing anything.
+func (fc *FaunaClient) verifyKeyAccess() error {
+	_, err := fc.client.Query(f.Paginate(f.Keys(), f.Size(1)))
+	return err
+}
+
+func (fc *FaunaClient) queryKey(expr f.Expr) (*FaunaKey, error) {
+	res, err := fc.client.Query(expr)
 	if err != nil {
 		return nil, err
 	}
@@ -78,49 +447,49 @@ func (fc *FaunaClient) createKey(role *FaunaRoleEntry) (*FaunaKey, error) {
 }
 
 // NOTE: The caller is required to ensure that b.clientMutex is at least read locked
-func nonCachedClient(ctx context.Context, s logical.Storage, logger hclog.Logger) (*FaunaClient, error) {
-	var faunaSecret string
-	var endpoint string
-	var endpointConfig f.ClientConfig
-
-	entry, err := s.Get(ctx, "config/root")
+func nonCachedClient(ctx context.Context, s logical.Storage, connection string, logger hclog.Logger) (*FaunaClient, error) {
+	config, err := readRootConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
-	if entry != nil {
-		var config rootConfig
-		if err := entry.DecodeJSON(&config); err != nil {
-			return nil, errwrap.Wrapf("error reading root configuration: {{err}}", err)
+	if config == nil {
+		if connection != "" {
+			return nil, fmt.Errorf("connection %q is not configured", connection)
 		}
-
-		faunaSecret = config.Secret
-		endpoint = config.Endpoint
+		config = &rootConfig{}
 	}
 
-	httpClient := f.HTTP(cleanhttp.DefaultClient())
+	return config.newClient(config.Secret, logger)
+}
 
-	if endpoint != "" {
-		endpointConfig = f.Endpoint(endpoint)
-	} else {
-		endpointConfig = func(cli *f.FaunaClient) {}
+// newFaunaClient builds a client for secret talking to endpoint, or to the
+// default Fauna endpoint when endpoint is empty.
+func newFaunaClient(faunaSecret, endpoint string, logger hclog.Logger) (*FaunaClient, error) {
+	if endpoint == "" {
+		endpoint = defaultEndpoint
 	}
 
+	httpClient := cleanhttp.DefaultClient()
+
 	// observer := f.Observer(func(qr *f.QueryResult) {
 	// 	logger.Debug(fmt.Sprintf("Query: %s\nResult: %s", qr.Query, qr.Result))
 	// })
 
 	faunaClient := f.NewFaunaClient(
 		faunaSecret,
-		endpointConfig,
-		httpClient)
+		f.Endpoint(endpoint),
+		f.HTTP(httpClient))
 
 	if faunaClient == nil {
 		return nil, fmt.Errorf("could not obtain Fauna client")
 	}
 
 	client := &FaunaClient{
-		client: faunaClient,
-		logger: logger,
+		client:     faunaClient,
+		httpClient: httpClient,
+		endpoint:   endpoint,
+		secret:     faunaSecret,
+		logger:     logger,
 	}
 
 	return client, nil
@@ -0,0 +1,147 @@
+package fauna
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	connectionTypeDatabase = "database"
+	connectionTypeAccount  = "account"
+
+	defaultAccountEndpoint = "https://account.fauna.com"
+	defaultRegionGroup     = "us-std"
+
+	// accountAPIPath is the prefix of the Fauna Account API
+	accountAPIPath = "/api/v2"
+)
+
+// accountDatabase is a top-level database as returned by the Account API.
+type accountDatabase struct {
+	Name string `json:"name"`
+	Path string `json:"path"` // "<region group>/<name>"
+}
+
+// accountKey is a key as returned by the Account API.
+type accountKey struct {
+	ID       string `json:"id"`
+	Secret   string `json:"secret"`
+	Database string `json:"database"`
+	Role     string `json:"role"`
+}
+
+// isAccount reports whether the client holds an account key and talks to
+// the Fauna Account API rather than to a database.
+func (fc *FaunaClient) isAccount() bool {
+	return fc.connectionType == connectionTypeAccount
+}
+
+// accountRequest sends a request to the Account API, encoding body as JSON
+// if given, and decodes the response into out if given.
+func (fc *FaunaClient) accountRequest(method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(fc.endpoint, "/")+accountAPIPath+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+fc.secret)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := fc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var res struct {
+			Code   string `json:"code"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(raw, &res); err != nil || res.Code == "" {
+			return fmt.Errorf("unexpected response from the Fauna Account API: %s", resp.Status)
+		}
+		return fmt.Errorf("%s: %s", res.Code, res.Reason)
+	}
+
+	if out == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// createAccountDatabase creates a top-level database called name in the
+// client's region group and returns its path.
+func (fc *FaunaClient) createAccountDatabase(name string) (string, error) {
+	var database accountDatabase
+	err := fc.accountRequest(http.MethodPost, "/databases", map[string]any{
+		"name":         name,
+		"region_group": fc.regionGroup,
+	}, &database)
+	if err != nil {
+		return "", err
+	}
+	if database.Path == "" {
+		database.Path = fc.regionGroup + "/" + name
+	}
+	return database.Path, nil
+}
+
+// deleteAccountDatabase deletes the top-level database at path, along with
+// everything in it. Databases that no longer exist are ignored.
+func (fc *FaunaClient) deleteAccountDatabase(path string) error {
+	err := fc.accountRequest(http.MethodDelete, "/databases/"+accountPathEscape(path), nil, nil)
+	if err != nil && strings.HasPrefix(err.Error(), "not_found:") {
+		return nil
+	}
+	return err
+}
+
+// createAccountKey creates a key with role for the top-level database at
+// path. role is a built-in role.
+func (fc *FaunaClient) createAccountKey(path, role string) (*accountKey, error) {
+	var key accountKey
+	err := fc.accountRequest(http.MethodPost, "/keys", map[string]any{
+		"database": path,
+		"role":     role,
+	}, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// verifyAccountAccess checks that the Account API accepts the client's
+// account key, without changing anything.
+func (fc *FaunaClient) verifyAccountAccess() error {
+	return fc.accountRequest(http.MethodGet, "/databases?max_results=1", nil, nil)
+}
+
+// accountPathEscape escapes each segment of a database path for use in an
+// Account API URL.
+func accountPathEscape(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
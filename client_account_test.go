@@ -0,0 +1,179 @@
+package fauna
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_AccountConnection(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, _ := testBackendWithFauna(t, s)
+	fa := newFakeAccount("account-key")
+	t.Cleanup(fa.Close)
+
+	writeConnection := func(data map[string]any) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "config/connections/account",
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeConnection(map[string]any{"secret": "wrong-key", "endpoint": fa.URL, "type": "account"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a rejected account key: resp:%#v", resp)
+	}
+	if resp := writeConnection(map[string]any{"secret": "account-key", "endpoint": fa.URL, "type": "account", "rotation_period": "720h"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for automatic rotation of an account key: resp:%#v", resp)
+	}
+	if resp := writeConnection(map[string]any{"secret": "account-key", "endpoint": fa.URL, "type": "account", "region_group": "eu-std"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: connection writing failed: resp:%#v", resp)
+	}
+	if resp := writeConnection(map[string]any{"secret": "account-key", "endpoint": fa.URL, "type": "database"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error changing the connection type: resp:%#v", resp)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "config/connections/account",
+	})
+	if err != nil || resp == nil || resp.Data["type"] != connectionTypeAccount || resp.Data["region_group"] != "eu-std" {
+		t.Fatalf("expected an account connection in eu-std: resp:%#v\n err: %v", resp, err)
+	}
+	if len(resp.Warnings) != 0 {
+		t.Fatalf("expected no warnings reading the connection, got %v", resp.Warnings)
+	}
+
+	testWriteRole(t, b, s, "tenant", map[string]any{
+		"type":                   "database",
+		"role":                   "server",
+		"database_name_template": "tenant-{{ .DisplayName }}",
+		"connection":             "account",
+	})
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Storage:     s,
+		Path:        "tenant",
+		DisplayName: "acme",
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if resp.Data["database"] != "eu-std/tenant-acme" || !fa.hasDatabase("eu-std/tenant-acme") {
+		t.Fatalf("expected a top-level database eu-std/tenant-acme, got %#v", resp.Data)
+	}
+	key := fa.keyBySecret(resp.Data["secret"].(string))
+	if key == nil || key["database"] != "eu-std/tenant-acme" || key["role"] != "server" {
+		t.Fatalf("expected a server key for the database, got %#v", key)
+	}
+	if n := testWALCount(t, s); n != 0 {
+		t.Fatalf("expected no WAL entries, got %d", n)
+	}
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if fa.hasDatabase("eu-std/tenant-acme") || fa.keyBySecret(resp.Data["secret"].(string)) != nil {
+		t.Fatal("expected the database and its key to be deleted")
+	}
+
+	// Revoking again finds nothing left to delete
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoking a deleted database failed: %v", err)
+	}
+
+	// A failed key creation removes the database it was for
+	fa.failNext("POST /keys", 1)
+	if resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Storage:     s,
+		Path:        "tenant",
+		DisplayName: "globex",
+	}); err == nil {
+		t.Fatalf("expected key creation to fail: resp:%#v", resp)
+	}
+	if fa.hasDatabase("eu-std/tenant-globex") {
+		t.Fatal("expected the database to be rolled back")
+	}
+	if n := testWALCount(t, s); n != 0 {
+		t.Fatalf("expected no WAL entries, got %d", n)
+	}
+
+	// An existing database is never taken over, nor deleted by the rollback
+	fa.databases["eu-std/tenant-initech"] = true
+	if resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Storage:     s,
+		Path:        "tenant",
+		DisplayName: "initech",
+	}); err == nil {
+		t.Fatalf("expected database creation to fail: resp:%#v", resp)
+	}
+	if !fa.hasDatabase("eu-std/tenant-initech") {
+		t.Fatal("expected the existing database to be kept")
+	}
+
+	// Only top-level databases are managed through the Account API
+	testWriteRole(t, b, s, "nested", map[string]any{"type": "database", "role": "admin", "database": "app", "connection": "account"})
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server", "connection": "account"})
+	for _, role := range []string{"nested", "deploy"} {
+		if resp, err := testReadKey(b, s, role, nil); err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error over an account connection: resp:%#v\n err: %v", role, resp, err)
+		}
+	}
+
+	// Account API keys have a built-in role and no data
+	for name, data := range map[string]map[string]any{
+		"custom role":   {"role": "roles/reader"},
+		"extra":         {"role": "server", "extra": map[string]any{"team": "payments"}},
+		"name template": {"role": "server", "name_template": "{{.DisplayName}}"},
+	} {
+		data["type"] = "database"
+		data["connection"] = "account"
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "roles/bad",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error for an account connection: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+	legacy := &FaunaRoleEntry{Type: "database", Role: "roles/reader", Connection: "account"}
+	if err := setFaunaRole(ctx, s, "legacy", legacy); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := testReadKey(b, s, "legacy", nil); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error issuing a custom role over an account connection: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root/account",
+	})
+	if err == nil && (resp == nil || !resp.IsError()) {
+		t.Fatalf("expected an error rotating an account key: resp:%#v", resp)
+	}
+}
@@ -0,0 +1,189 @@
+package fauna
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiVersion4  = "4"
+	apiVersion10 = "10"
+
+	// fqlQueryPath is where the Fauna v10 API takes FQL v10 queries
+	fqlQueryPath = "/query/1"
+)
+
+// faunaKeyV10 is a key document as returned by the Fauna v10 API.
+type faunaKeyV10 struct {
+	ID       string         `json:"id"`
+	Secret   string         `json:"secret"`
+	Role     string         `json:"role"`
+	Database string         `json:"database"`
+	Data     map[string]any `json:"data"`
+}
+
+// isV10 reports whether the client talks to the Fauna v10 API. Connections
+// configured before api_version existed use FQL v4.
+func (fc *FaunaClient) isV10() bool {
+	return fc.apiVersion == apiVersion10
+}
+
+// requireV4 returns an error naming what is not available over the Fauna
+// v10 API or the Account API, if that is what the client uses.
+func (fc *FaunaClient) requireV4(what string) error {
+	if fc.isAccount() {
+		return fmt.Errorf("%s is not available on %s connections", what, connectionTypeAccount)
+	}
+	if fc.isV10() {
+		return fmt.Errorf("%s requires a connection with api_version %s", what, apiVersion4)
+	}
+	return nil
+}
+
+// queryFQL runs an FQL v10 query, binding arguments to the variables it
+// refers to, and decodes the data of the result into out, if given.
+func (fc *FaunaClient) queryFQL(query string, arguments map[string]any, out any) error {
+	encoded := make(map[string]any, len(arguments))
+	for k, v := range arguments {
+		encoded[k] = taggedValue(v)
+	}
+	body, err := json.Marshal(map[string]any{
+		"query":     query,
+		"arguments": encoded,
+	})
+	if err != nil {
+		return err
+	}
+
+	secret := fc.secret
+	if fc.scope != "" {
+		secret = scopedSecret(fc.secret, fc.scope, "admin")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(fc.endpoint, "/")+fqlQueryPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Format", "simple")
+
+	resp, err := fc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Data  json.RawMessage `json:"data"`
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return fmt.Errorf("unexpected response from Fauna: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 || res.Error != nil {
+		if res.Error == nil {
+			return fmt.Errorf("unexpected response from Fauna: %s", resp.Status)
+		}
+		return fmt.Errorf("%s: %s", res.Error.Code, res.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Data, out)
+}
+
+// taggedValue encodes a query argument in the tagged format of the v10 API,
+// which tells integers from floats and escapes objects with keys starting
+// with "@".
+func taggedValue(v any) any {
+	switch v := v.(type) {
+	case int:
+		return map[string]any{"@int": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"@long": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"@double": strconv.FormatFloat(v, 'g', -1, 64)}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return taggedValue(i)
+		}
+		return map[string]any{"@double": v.String()}
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = taggedValue(item)
+		}
+		return out
+	case []string:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = item
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		escape := false
+		for k, item := range v {
+			out[k] = taggedValue(item)
+			escape = escape || strings.HasPrefix(k, "@")
+		}
+		if escape {
+			return map[string]any{"@object": out}
+		}
+		return out
+	}
+	return v
+}
+
+// createKeyV10 creates a key for role with Key.create. Keys for a database
+// are created in its parent, so the returned key lives in the database at
+// the returned path, relative to the client.
+func (fc *FaunaClient) createKeyV10(role *FaunaRoleEntry) (*faunaKeyV10, string, error) {
+	params := map[string]any{
+		"role": strings.TrimPrefix(role.Role, "roles/"),
+	}
+
+	var parent string
+	if role.Database != "" {
+		var name string
+		parent, name = splitDatabasePath(role.Database)
+		params["database"] = name
+	}
+
+	if role.Extra != nil {
+		params["data"] = role.Extra
+	}
+
+	var key faunaKeyV10
+	if err := fc.scoped(parent).queryFQL("Key.create(params)", map[string]any{"params": params}, &key); err != nil {
+		return nil, "", err
+	}
+	return &key, parent, nil
+}
+
+// deleteKeyV10 deletes the key with the given ID. Keys that no longer exist
+// are ignored.
+func (fc *FaunaClient) deleteKeyV10(id string) error {
+	return fc.queryFQL("Key.byId(id)?.delete()", map[string]any{"id": id}, nil)
+}
+
+// verifyKeyAccessV10 checks that the client's secret is accepted by the
+// Fauna v10 API and may read keys in its database.
+func (fc *FaunaClient) verifyKeyAccessV10() error {
+	return fc.queryFQL("Key.all().first()", nil, nil)
+}
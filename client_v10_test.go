@@ -0,0 +1,138 @@
+package fauna
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_APIVersion10(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	ff.addDatabase("app")
+	ff.addDatabase("app/child")
+
+	writeConnection := func(data map[string]any) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "config/connections/v10",
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeConnection(map[string]any{"secret": "root-secret", "endpoint": ff.URL, "api_version": "3"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an unknown api_version: resp:%#v", resp)
+	}
+	if resp := writeConnection(map[string]any{"secret": "root-secret", "endpoint": ff.URL, "api_version": "10", "rotation_period": "720h"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for automatic rotation over v10: resp:%#v", resp)
+	}
+	if resp := writeConnection(map[string]any{"secret": "root-secret", "endpoint": ff.URL, "api_version": "10"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: connection writing failed: resp:%#v", resp)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "config/connections/v10",
+	})
+	if err != nil || resp == nil || resp.Data["api_version"] != apiVersion10 {
+		t.Fatalf("expected the connection to use api_version 10: resp:%#v\n err: %v", resp, err)
+	}
+
+	testWriteRole(t, b, s, "v10", map[string]any{
+		"role":       "server",
+		"database":   "app/child",
+		"extra":      map[string]any{"team": "payments", "shard": json.Number("3")},
+		"connection": "v10",
+	})
+
+	resp, err = testReadKey(b, s, "v10", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	internal := resp.Secret.InternalData
+	if internal["api_version"] != apiVersion10 || internal["parent"] != "app" {
+		t.Fatalf("expected the lease to record a v10 key in app, got %#v", internal)
+	}
+	key := ff.keyDoc(resp.Data["secret"].(string))
+	if key == nil || key["__v10"] != true || key["__scope"] != "app/child" || key["role"] != "server" {
+		t.Fatalf("expected a v10 key for app/child, got %#v", key)
+	}
+	if shard := key["data"].(map[string]any)["shard"]; shard != json.Number("3") {
+		t.Fatalf("expected extra to keep its number, got %#v", shard)
+	}
+	if metadata, _ := key["data"].(map[string]any)["vault"].(map[string]any); metadata["role"] != "v10" {
+		t.Fatalf("expected the key to carry its Vault metadata, got %#v", key["data"])
+	}
+	if n := testWALCount(t, s); n != 0 {
+		t.Fatalf("expected no WAL entries, got %d", n)
+	}
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if ff.keyExists(resp.Data["secret"].(string)) {
+		t.Fatal("expected the v10 key to be deleted")
+	}
+
+	// Roles written before role types are keys too
+	if err := setFaunaRole(ctx, s, "legacy", &FaunaRoleEntry{Role: "server", Connection: "v10"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := testReadKey(b, s, "legacy", nil); err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation for a legacy role failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	// A failed create leaves no WAL entry behind
+	ff.failNext("Key.create(params)", 1)
+	if resp, err := testReadKey(b, s, "v10", nil); err == nil {
+		t.Fatalf("expected key creation to fail: resp:%#v", resp)
+	}
+	if n := testWALCount(t, s); n != 0 {
+		t.Fatalf("expected no WAL entries, got %d", n)
+	}
+
+	// Keys on v4 connections record the protocol too
+	testWriteRole(t, b, s, "v4", map[string]any{"role": "server"})
+	resp, err = testReadKey(b, s, "v4", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if resp.Secret.InternalData["api_version"] != apiVersion4 {
+		t.Fatalf("expected the lease to record api_version 4, got %#v", resp.Secret.InternalData)
+	}
+
+	// What only FQL v4 can do is refused on v10 connections
+	testWriteRole(t, b, s, "ci", map[string]any{"type": "database", "role": "admin", "connection": "v10"})
+	if resp, err := testReadKey(b, s, "ci", nil); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a database role over v10: resp:%#v\n err: %v", resp, err)
+	}
+	for path, data := range map[string]map[string]any{
+		"config/rotate-root/v10": nil,
+		"static-roles/v10":       {"role": "server", "rotation_period": "720h", "connection": "v10"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      path,
+			Data:      data,
+		})
+		if err == nil && (resp == nil || !resp.IsError()) {
+			t.Errorf("%s: expected an error over v10: resp:%#v", path, resp)
+		}
+	}
+}
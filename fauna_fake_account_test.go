@@ -0,0 +1,149 @@
+package fauna
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeAccount is a minimal in-memory stand-in for the Fauna Account API,
+// covering the top-level database and key endpoints the backend uses.
+type fakeAccount struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	key       string
+	nextID    int
+	databases map[string]bool              // by path
+	keys      map[string]map[string]string // by ID
+	fail      map[string]int
+}
+
+func newFakeAccount(accountKey string) *fakeAccount {
+	fa := &fakeAccount{
+		key:       accountKey,
+		databases: map[string]bool{},
+		keys:      map[string]map[string]string{},
+		fail:      map[string]int{},
+	}
+	fa.Server = httptest.NewServer(http.HandlerFunc(fa.handle))
+	return fa
+}
+
+// failNext makes the next n requests to op (e.g. "POST /keys") fail.
+func (fa *fakeAccount) failNext(op string, n int) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	fa.fail[op] = n
+}
+
+func (fa *fakeAccount) hasDatabase(path string) bool {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	return fa.databases[path]
+}
+
+// keyBySecret returns the database path and role of the key with secret.
+func (fa *fakeAccount) keyBySecret(secret string) map[string]string {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	for _, key := range fa.keys {
+		if key["secret"] == secret {
+			return key
+		}
+	}
+	return nil
+}
+
+func (fa *fakeAccount) handle(w http.ResponseWriter, r *http.Request) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+fa.key {
+		fa.writeError(w, http.StatusUnauthorized, "unauthorized", "Invalid account key")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, accountAPIPath)
+	op := r.Method + " " + path
+	if strings.HasPrefix(path, "/databases/") {
+		op = r.Method + " /databases/"
+	}
+	if n := fa.fail[op]; n > 0 {
+		fa.fail[op] = n - 1
+		fa.writeError(w, http.StatusInternalServerError, "internal_error", "induced failure")
+		return
+	}
+
+	var body map[string]string
+	if r.Body != nil && r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			fa.writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+	}
+
+	switch op {
+	case "GET /databases":
+		var results []map[string]string
+		for path := range fa.databases {
+			results = append(results, map[string]string{"path": path})
+		}
+		fa.write(w, http.StatusOK, map[string]any{"results": results})
+
+	case "POST /databases":
+		path := body["region_group"] + "/" + body["name"]
+		if fa.databases[path] {
+			fa.writeError(w, http.StatusConflict, "already_exists", "Database already exists")
+			return
+		}
+		fa.databases[path] = true
+		fa.write(w, http.StatusCreated, map[string]string{"name": body["name"], "path": path})
+
+	case "DELETE /databases/":
+		path := strings.TrimPrefix(path, "/databases/")
+		if !fa.databases[path] {
+			fa.writeError(w, http.StatusNotFound, "not_found", "Database not found")
+			return
+		}
+		delete(fa.databases, path)
+		for id, key := range fa.keys {
+			if key["database"] == path {
+				delete(fa.keys, id)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "POST /keys":
+		if !fa.databases[body["database"]] {
+			fa.writeError(w, http.StatusNotFound, "not_found", "Database not found")
+			return
+		}
+		fa.nextID++
+		id := strconv.Itoa(fa.nextID)
+		key := map[string]string{
+			"id":       id,
+			"secret":   "fn-account-" + id,
+			"database": body["database"],
+			"role":     body["role"],
+		}
+		fa.keys[id] = key
+		fa.write(w, http.StatusCreated, key)
+
+	default:
+		fa.writeError(w, http.StatusNotFound, "not_found", "No such endpoint")
+	}
+}
+
+func (fa *fakeAccount) write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (fa *fakeAccount) writeError(w http.ResponseWriter, status int, code, reason string) {
+	fa.write(w, status, map[string]string{"code": code, "reason": reason})
+}
@@ -0,0 +1,1117 @@
+package fauna
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeFauna is a minimal in-memory stand-in for the Fauna FQL v4 HTTP API,
+// with the few FQL v10 queries the backend sends in fauna_fake_v10_test.go.
+// It evaluates the subset of the query language used by this backend, which
+// is enough to exercise key issuance and revocation without a real cluster.
+type fakeFauna struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	secret  string
+	caller  string
+	nextID  int
+	docs    map[string]map[string]any
+	fail    map[string]int
+	queries []string
+
+	// hideOuterKeys makes keys living outside the caller's database look
+	// like they don't exist when deleted
+	hideOuterKeys bool
+}
+
+type fakeError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *fakeError) Error() string { return e.code + ": " + e.description }
+
+// fakeRef mirrors a Fauna reference. Native collections (keys, databases,
+// roles, ...) have a nil coll; db holds the slash separated database path
+// the reference lives in, relative to the root of the fake.
+type fakeRef struct {
+	id   string
+	coll *fakeRef
+	db   string
+}
+
+func (r *fakeRef) class() string {
+	if r.coll == nil {
+		return r.id
+	}
+	if r.coll.coll == nil {
+		return r.coll.id
+	}
+	return "collections/" + r.coll.id
+}
+
+func (r *fakeRef) key() string {
+	return r.db + "|" + r.class() + "/" + r.id
+}
+
+type fakeVars map[string]any
+
+func newFakeFauna(rootSecret string) *fakeFauna {
+	ff := &fakeFauna{
+		secret: rootSecret,
+		docs:   map[string]map[string]any{},
+		fail:   map[string]int{},
+	}
+	ff.Server = httptest.NewServer(http.HandlerFunc(ff.handle))
+	return ff
+}
+
+// failNext makes the next n evaluations of op (e.g. "create_key") fail.
+func (ff *fakeFauna) failNext(op string, n int) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	ff.fail[op] = n
+}
+
+// count returns the number of documents stored in class at the given
+// database path.
+func (ff *fakeFauna) count(db, class string) int {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	prefix := db + "|" + class + "/"
+	n := 0
+	for k := range ff.docs {
+		if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			n++
+		}
+	}
+	return n
+}
+
+// doc returns a copy of the stored document for class/id at db.
+func (ff *fakeFauna) doc(db, class, id string) map[string]any {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	d, ok := ff.docs[db+"|"+class+"/"+id]
+	if !ok {
+		return nil
+	}
+	out := make(map[string]any, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
+
+// docs returns the ids of every document in class at db, sorted.
+func (ff *fakeFauna) ids(db, class string) []string {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	prefix := db + "|" + class + "/"
+	var out []string
+	for k := range ff.docs {
+		if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+			out = append(out, k[len(prefix):])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (ff *fakeFauna) handle(w http.ResponseWriter, r *http.Request) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	if r.URL.Path == fqlQueryPath {
+		ff.handleFQL(w, r)
+		return
+	}
+
+	scope, ok := ff.authenticate(r.Header.Get("Authorization"))
+	if !ok {
+		ff.writeError(w, &fakeError{401, "unauthorized", "Unauthorized"})
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	var query any
+	if err := dec.Decode(&query); err != nil {
+		ff.writeError(w, &fakeError{400, "invalid expression", err.Error()})
+		return
+	}
+	raw, _ := json.Marshal(query)
+	ff.queries = append(ff.queries, string(raw))
+
+	res, err := ff.eval(query, scope, fakeVars{})
+	if err != nil {
+		fe, ok := err.(*fakeError)
+		if !ok {
+			fe = &fakeError{400, "invalid argument", err.Error()}
+		}
+		ff.writeError(w, fe)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Txn-Time", strconv.FormatInt(time.Now().UnixMicro(), 10))
+	json.NewEncoder(w).Encode(map[string]any{"resource": ff.render(res, scope)})
+}
+
+func (ff *fakeFauna) writeError(w http.ResponseWriter, fe *fakeError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fe.status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []any{map[string]any{"code": fe.code, "description": fe.description}},
+	})
+}
+
+// authenticate resolves the bearer secret into the database path it is
+// scoped to. Scoped secrets of the form secret:db/path:role are honoured.
+func (ff *fakeFauna) authenticate(header string) (string, bool) {
+	secret := strings.TrimPrefix(header, "Bearer ")
+	parts := strings.SplitN(secret, ":", 3)
+
+	var scope string
+	ff.caller = "admin"
+	switch {
+	case parts[0] == ff.secret && ff.secret != "":
+	default:
+		key := ff.keyBySecret(parts[0])
+		if key == nil {
+			return "", false
+		}
+		scope = key["__scope"].(string)
+		ff.caller, _ = key["role"].(string)
+	}
+	if len(parts) > 1 {
+		scope = joinDB(scope, parts[1])
+	}
+	if len(parts) > 2 {
+		ff.caller = parts[2]
+	}
+	return scope, true
+}
+
+func (ff *fakeFauna) keyBySecret(secret string) map[string]any {
+	for _, d := range ff.docs {
+		if d["__secret"] == secret {
+			return d
+		}
+	}
+	return nil
+}
+
+func joinDB(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	}
+	return parent + "/" + child
+}
+
+func (ff *fakeFauna) failing(op string) error {
+	if n := ff.fail[op]; n > 0 {
+		ff.fail[op] = n - 1
+		return &fakeError{500, "internal server error", "injected failure for " + op}
+	}
+	return nil
+}
+
+func (ff *fakeFauna) eval(expr any, scope string, vars fakeVars) (any, error) {
+	switch e := expr.(type) {
+	case nil, string, bool, json.Number:
+		return e, nil
+	case []any:
+		out := make([]any, len(e))
+		for i, item := range e {
+			v, err := ff.eval(item, scope, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case map[string]any:
+		return ff.evalCall(e, scope, vars)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func (ff *fakeFauna) evalCall(e map[string]any, scope string, vars fakeVars) (any, error) {
+	arg := func(name string) (any, error) { return ff.eval(e[name], scope, vars) }
+
+	for op := range e {
+		if err := ff.failing(op); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case has(e, "object"):
+		obj, ok := e["object"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid object")
+		}
+		out := make(map[string]any, len(obj))
+		for k, v := range obj {
+			val, err := ff.eval(v, scope, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = val
+		}
+		return out, nil
+
+	case has(e, "@ref"):
+		return ff.parseRef(e["@ref"], scope)
+
+	case has(e, "@ts"):
+		return fakeTime(e["@ts"].(string)), nil
+
+	case has(e, "time"):
+		v, err := arg("time")
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := v.(string); ok {
+			return fakeTime(s), nil
+		}
+		return v, nil
+
+	case has(e, "now"):
+		return fakeTime(time.Now().UTC().Format(time.RFC3339Nano)), nil
+
+	case has(e, "time_add"):
+		base, err := arg("time_add")
+		if err != nil {
+			return nil, err
+		}
+		offset, err := arg("offset")
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(base.(fakeTime)))
+		if err != nil {
+			return nil, err
+		}
+		n, _ := offset.(json.Number).Int64()
+		unit, _ := e["unit"].(string)
+		d := time.Duration(n)
+		switch strings.TrimSuffix(unit, "s") {
+		case "second":
+			d *= time.Second
+		case "minute":
+			d *= time.Minute
+		case "hour":
+			d *= time.Hour
+		case "day":
+			d *= 24 * time.Hour
+		default:
+			d *= time.Millisecond
+		}
+		return fakeTime(t.Add(d).Format(time.RFC3339Nano)), nil
+
+	case has(e, "var"):
+		v, ok := vars[e["var"].(string)]
+		if !ok {
+			return nil, fmt.Errorf("unbound variable %v", e["var"])
+		}
+		return v, nil
+
+	case has(e, "let"):
+		inner := fakeVars{}
+		for k, v := range vars {
+			inner[k] = v
+		}
+		var bindings []any
+		switch b := e["let"].(type) {
+		case []any:
+			bindings = b
+		case map[string]any:
+			bindings = []any{b}
+		}
+		for _, b := range bindings {
+			for k, v := range b.(map[string]any) {
+				val, err := ff.eval(v, scope, inner)
+				if err != nil {
+					return nil, err
+				}
+				inner[k] = val
+			}
+		}
+		return ff.eval(e["in"], scope, inner)
+
+	case has(e, "do"):
+		var last any
+		items, _ := e["do"].([]any)
+		for _, item := range items {
+			v, err := ff.eval(item, scope, vars)
+			if err != nil {
+				return nil, err
+			}
+			last = v
+		}
+		return last, nil
+
+	case has(e, "if"):
+		cond, err := arg("if")
+		if err != nil {
+			return nil, err
+		}
+		if cond == true {
+			return arg("then")
+		}
+		return arg("else")
+
+	case has(e, "select"):
+		from, err := arg("from")
+		if err != nil {
+			return nil, err
+		}
+		path, err := arg("select")
+		if err != nil {
+			return nil, err
+		}
+		v, ok := fakeSelect(from, path)
+		if !ok {
+			if has(e, "default") {
+				return arg("default")
+			}
+			return nil, &fakeError{404, "value not found", fmt.Sprintf("value not found at path %v", path)}
+		}
+		return v, nil
+
+	case has(e, "database"), has(e, "role"), has(e, "collection"), has(e, "index"), has(e, "function"), has(e, "access_provider"):
+		for _, class := range []string{"database", "role", "collection", "index", "function", "access_provider"} {
+			if has(e, class) {
+				return ff.nativeRef(class, e, scope, vars)
+			}
+		}
+
+	case has(e, "keys"), has(e, "tokens"), has(e, "databases"), has(e, "roles"), has(e, "collections"):
+		for _, class := range []string{"keys", "tokens", "databases", "roles", "collections"} {
+			if has(e, class) {
+				db := scope
+				if e[class] != nil {
+					s, err := arg(class)
+					if err != nil {
+						return nil, err
+					}
+					db = s.(*fakeRef).path()
+				}
+				return &fakeRef{id: class, db: db}, nil
+			}
+		}
+
+	case has(e, "ref") && has(e, "id"):
+		coll, err := arg("ref")
+		if err != nil {
+			return nil, err
+		}
+		id, err := arg("id")
+		if err != nil {
+			return nil, err
+		}
+		c := coll.(*fakeRef)
+		return &fakeRef{id: fmt.Sprint(id), coll: c, db: c.db}, nil
+
+	case has(e, "paginate"):
+		set, err := arg("paginate")
+		if err != nil {
+			return nil, err
+		}
+		ref, ok := set.(*fakeRef)
+		if !ok || ref.coll != nil {
+			return nil, fmt.Errorf("unsupported set %v", set)
+		}
+		if ref.id == "keys" && ff.caller != "admin" {
+			return nil, &fakeError{403, "permission denied", "Insufficient privileges to perform the action."}
+		}
+		prefix := ref.db + "|" + ref.id + "/"
+		var data []any
+		for k, d := range ff.docs {
+			if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+				data = append(data, d["ref"])
+			}
+		}
+		return map[string]any{"data": data}, nil
+
+	case has(e, "key_from_secret"):
+		s, err := arg("key_from_secret")
+		if err != nil {
+			return nil, err
+		}
+		secret := strings.SplitN(s.(string), ":", 2)[0]
+		key := ff.keyBySecret(secret)
+		if key == nil {
+			if secret == ff.secret {
+				return nil, &fakeError{404, "instance not found", "root secret has no key document"}
+			}
+			return nil, &fakeError{404, "instance not found", "key not found"}
+		}
+		return key, nil
+
+	case has(e, "create_key"):
+		params, err := arg("create_key")
+		if err != nil {
+			return nil, err
+		}
+		return ff.createKey(params.(map[string]any), scope)
+
+	case has(e, "create_database"), has(e, "create_role"), has(e, "create_collection"), has(e, "create_index"), has(e, "create_function"), has(e, "create_access_provider"):
+		for _, class := range []string{"database", "role", "collection", "index", "function", "access_provider"} {
+			if has(e, "create_"+class) {
+				params, err := arg("create_" + class)
+				if err != nil {
+					return nil, err
+				}
+				return ff.createNamed(class, params.(map[string]any), scope)
+			}
+		}
+
+	case has(e, "create"):
+		target, err := arg("create")
+		if err != nil {
+			return nil, err
+		}
+		params, err := arg("params")
+		if err != nil {
+			return nil, err
+		}
+		return ff.create(target.(*fakeRef), params.(map[string]any))
+
+	case has(e, "match"):
+		index, err := arg("match")
+		if err != nil {
+			return nil, err
+		}
+		terms, err := arg("terms")
+		if err != nil {
+			return nil, err
+		}
+		return ff.match(index.(*fakeRef), terms)
+
+	case has(e, "get"):
+		ref, err := arg("get")
+		if err != nil {
+			return nil, err
+		}
+		return ff.get(ref)
+
+	case has(e, "exists"):
+		ref, err := arg("exists")
+		if err != nil {
+			return nil, err
+		}
+		if set, ok := ref.(fakeSet); ok {
+			return len(set) > 0, nil
+		}
+		r, ok := ref.(*fakeRef)
+		if !ok {
+			return false, nil
+		}
+		_, found := ff.docs[r.key()]
+		return found, nil
+
+	case has(e, "delete"):
+		ref, err := arg("delete")
+		if err != nil {
+			return nil, err
+		}
+		doc, err := ff.get(ref)
+		if err != nil {
+			return nil, err
+		}
+		if r := ref.(*fakeRef); ff.hideOuterKeys && r.class() == "keys" && r.db != scope && !strings.HasPrefix(r.db, scope+"/") {
+			return nil, &fakeError{404, "instance not found", "Document not found: " + r.key()}
+		}
+		ff.remove(ref.(*fakeRef))
+		return doc, nil
+
+	case has(e, "update"):
+		ref, err := arg("update")
+		if err != nil {
+			return nil, err
+		}
+		params, err := arg("params")
+		if err != nil {
+			return nil, err
+		}
+		doc, err := ff.get(ref)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range params.(map[string]any) {
+			if k == "data" {
+				data, _ := doc["data"].(map[string]any)
+				if data == nil {
+					data = map[string]any{}
+				}
+				for dk, dv := range v.(map[string]any) {
+					data[dk] = dv
+				}
+				v = data
+			}
+			doc[k] = v
+		}
+		return doc, nil
+	}
+
+	return nil, &fakeError{400, "invalid expression", fmt.Sprintf("unsupported call %v", keysOf(e))}
+}
+
+func (ff *fakeFauna) nativeRef(class string, e map[string]any, scope string, vars fakeVars) (any, error) {
+	name, err := ff.eval(e[class], scope, vars)
+	if err != nil {
+		return nil, err
+	}
+	db := scope
+	if e["scope"] != nil {
+		s, err := ff.eval(e["scope"], scope, vars)
+		if err != nil {
+			return nil, err
+		}
+		db = s.(*fakeRef).path()
+	}
+	plural := class + "s"
+	if class == "index" {
+		plural = "indexes"
+	}
+	return &fakeRef{id: fmt.Sprint(name), coll: &fakeRef{id: plural}, db: db}, nil
+}
+
+// path returns the database path a database reference points into.
+func (r *fakeRef) path() string {
+	if r.class() == "databases" {
+		return joinDB(r.db, r.id)
+	}
+	return r.db
+}
+
+func (ff *fakeFauna) parseRef(raw any, scope string) (*fakeRef, error) {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid ref %v", raw)
+	}
+	ref := &fakeRef{id: fmt.Sprint(m["id"]), db: scope}
+	if c, ok := m["collection"].(map[string]any); ok {
+		coll, err := ff.parseRef(c["@ref"], scope)
+		if err != nil {
+			return nil, err
+		}
+		ref.coll = coll
+	}
+	if d, ok := m["database"].(map[string]any); ok {
+		db, err := ff.parseRef(d["@ref"], scope)
+		if err != nil {
+			return nil, err
+		}
+		ref.db = db.path()
+		if ref.coll != nil {
+			ref.coll.db = ref.db
+		}
+	}
+	return ref, nil
+}
+
+func (ff *fakeFauna) newID() string {
+	ff.nextID++
+	return strconv.Itoa(300000000000000000 + ff.nextID)
+}
+
+func (ff *fakeFauna) createKey(params map[string]any, scope string) (any, error) {
+	id := ff.newID()
+	keyScope := scope
+	if db, ok := params["database"].(*fakeRef); ok {
+		if _, found := ff.docs[db.key()]; !found {
+			return nil, &fakeError{400, "invalid ref", "database not found"}
+		}
+		// Like Fauna, only keys for child databases can be created
+		if db.db != scope {
+			return nil, &fakeError{400, "invalid argument", "database must be a child of the current database"}
+		}
+		keyScope = db.path()
+	}
+	if role, ok := params["role"].(*fakeRef); ok {
+		if _, found := ff.docs[role.key()]; !found {
+			return nil, &fakeError{400, "invalid ref", "role not found"}
+		}
+	}
+	ref := &fakeRef{id: id, coll: &fakeRef{id: "keys"}, db: scope}
+	secret := "fnS" + id
+	doc := map[string]any{
+		"ref":           ref,
+		"ts":            json.Number(strconv.FormatInt(time.Now().UnixMicro(), 10)),
+		"hashed_secret": "hashed-" + secret,
+		"__secret":      secret,
+		"__scope":       keyScope,
+	}
+	for k, v := range params {
+		doc[k] = v
+	}
+	ff.docs[ref.key()] = doc
+
+	out := make(map[string]any, len(doc)+1)
+	for k, v := range doc {
+		out[k] = v
+	}
+	out["secret"] = secret
+	return out, nil
+}
+
+func (ff *fakeFauna) createNamed(class string, params map[string]any, scope string) (any, error) {
+	name, _ := params["name"].(string)
+	if name == "" {
+		return nil, &fakeError{400, "validation failed", "name is required"}
+	}
+	plural := class + "s"
+	if class == "index" {
+		plural = "indexes"
+	}
+	ref := &fakeRef{id: name, coll: &fakeRef{id: plural}, db: scope}
+	if _, exists := ff.docs[ref.key()]; exists {
+		return nil, &fakeError{400, "instance already exists", plural + " " + name + " already exists"}
+	}
+	doc := map[string]any{
+		"ref": ref,
+		"ts":  json.Number(strconv.FormatInt(time.Now().UnixMicro(), 10)),
+	}
+	for k, v := range params {
+		doc[k] = v
+	}
+	if class == "access_provider" {
+		doc["audience"] = "https://db.fauna.com/db/" + ff.newID()
+	}
+	ff.docs[ref.key()] = doc
+	return doc, nil
+}
+
+func (ff *fakeFauna) create(target *fakeRef, params map[string]any) (any, error) {
+	var ref *fakeRef
+	switch {
+	case target.coll == nil:
+		// Native collection such as Tokens()
+		ref = &fakeRef{id: ff.newID(), coll: &fakeRef{id: target.id}, db: target.db}
+	case target.class() == "collections":
+		if _, found := ff.docs[target.key()]; !found {
+			return nil, &fakeError{400, "invalid ref", "collection not found"}
+		}
+		ref = &fakeRef{id: ff.newID(), coll: target, db: target.db}
+	default:
+		ref = target
+		if _, found := ff.docs[ref.coll.key()]; !found {
+			return nil, &fakeError{400, "invalid ref", "collection not found"}
+		}
+		if _, exists := ff.docs[ref.key()]; exists {
+			return nil, &fakeError{400, "instance already exists", "document already exists"}
+		}
+	}
+	doc := map[string]any{
+		"ref": ref,
+		"ts":  json.Number(strconv.FormatInt(time.Now().UnixMicro(), 10)),
+	}
+	for k, v := range params {
+		doc[k] = v
+	}
+	if ref.class() == "tokens" {
+		secret := "fnE" + ref.id
+		doc["__secret"] = secret
+		doc["__scope"] = ref.db
+		ff.docs[ref.key()] = doc
+		out := make(map[string]any, len(doc)+1)
+		for k, v := range doc {
+			out[k] = v
+		}
+		out["secret"] = secret
+		return out, nil
+	}
+	ff.docs[ref.key()] = doc
+	return doc, nil
+}
+
+// fakeSet is the result of a match: the documents of the index source
+// whose term fields equal the given terms, in ref order.
+type fakeSet []*fakeRef
+
+func (ff *fakeFauna) match(index *fakeRef, terms any) (fakeSet, error) {
+	idx, found := ff.docs[index.key()]
+	if !found {
+		return nil, &fakeError{400, "invalid ref", "index not found"}
+	}
+	source, ok := idx["source"].(*fakeRef)
+	if !ok {
+		return nil, fmt.Errorf("unsupported index source %v", idx["source"])
+	}
+
+	want, ok := terms.([]any)
+	if !ok {
+		want = []any{terms}
+	}
+	fields, _ := idx["terms"].([]any)
+
+	prefix := source.db + "|collections/" + source.id + "/"
+	var keys []string
+	for k := range ff.docs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var set fakeSet
+	for _, k := range keys {
+		doc := ff.docs[k]
+		matches := true
+		for i, term := range fields {
+			field := term.(map[string]any)["field"]
+			v, ok := fakeSelect(doc, field)
+			if i < len(want) && (!ok || fmt.Sprint(v) != fmt.Sprint(want[i])) {
+				matches = false
+			}
+		}
+		if matches {
+			set = append(set, doc["ref"].(*fakeRef))
+		}
+	}
+	return set, nil
+}
+
+func (ff *fakeFauna) get(raw any) (map[string]any, error) {
+	if set, ok := raw.(fakeSet); ok {
+		if len(set) == 0 {
+			return nil, &fakeError{404, "instance not found", "Set not found."}
+		}
+		raw = set[0]
+	}
+	ref, ok := raw.(*fakeRef)
+	if !ok {
+		if doc, ok := raw.(map[string]any); ok {
+			return doc, nil
+		}
+		return nil, fmt.Errorf("invalid ref %v", raw)
+	}
+	doc, found := ff.docs[ref.key()]
+	if !found {
+		return nil, &fakeError{404, "instance not found", "Document not found: " + ref.key()}
+	}
+	return doc, nil
+}
+
+// remove deletes ref along with everything that lived inside it when ref
+// is a database.
+func (ff *fakeFauna) remove(ref *fakeRef) {
+	delete(ff.docs, ref.key())
+	if ref.class() != "databases" {
+		return
+	}
+	inner := ref.path()
+	within := func(db string) bool {
+		return db == inner || strings.HasPrefix(db, inner+"/")
+	}
+	for k, d := range ff.docs {
+		// Keys for the database live in its parent but go with it
+		scope, _ := d["__scope"].(string)
+		if within(k[:strings.Index(k, "|")]) || within(scope) {
+			delete(ff.docs, k)
+		}
+	}
+}
+
+type fakeTime string
+
+// render converts evaluated values back into Fauna's wire format relative to
+// the caller's scope, hiding internal bookkeeping fields.
+func (ff *fakeFauna) render(v any, scope string) any {
+	switch val := v.(type) {
+	case *fakeRef:
+		return map[string]any{"@ref": renderRef(val, scope)}
+	case fakeTime:
+		return map[string]any{"@ts": string(val)}
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			if strings.HasPrefix(k, "__") {
+				continue
+			}
+			out[k] = ff.render(item, scope)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = ff.render(item, scope)
+		}
+		return out
+	}
+	return v
+}
+
+func renderRef(r *fakeRef, scope string) map[string]any {
+	out := map[string]any{"id": r.id}
+	if r.coll != nil {
+		out["collection"] = map[string]any{"@ref": renderRef(&fakeRef{id: r.coll.id, coll: r.coll.coll, db: r.db}, scope)}
+	}
+	if r.coll != nil && r.db != scope {
+		rel := strings.TrimPrefix(strings.TrimPrefix(r.db, scope), "/")
+		parent, name := "", rel
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			parent, name = rel[:i], rel[i+1:]
+		}
+		out["database"] = map[string]any{"@ref": renderRef(&fakeRef{id: name, coll: &fakeRef{id: "databases"}, db: joinDB(scope, parent)}, scope)}
+	}
+	return out
+}
+
+func fakeSelect(from, path any) (any, bool) {
+	var steps []any
+	switch p := path.(type) {
+	case []any:
+		steps = p
+	default:
+		steps = []any{p}
+	}
+	cur := from
+	for _, step := range steps {
+		switch c := cur.(type) {
+		case map[string]any:
+			next, ok := c[fmt.Sprint(step)]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []any:
+			n, err := step.(json.Number).Int64()
+			if err != nil || int(n) >= len(c) {
+				return nil, false
+			}
+			cur = c[n]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func has(m map[string]any, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func keysOf(m map[string]any) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// testBackendWithFauna returns a backend configured against a fresh fake
+// Fauna server using s as its storage.
+func testBackendWithFauna(t *testing.T, s logical.Storage) (*backend, *fakeFauna) {
+	t.Helper()
+
+	ff := newFakeFauna("root-secret")
+	t.Cleanup(ff.Close)
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":   "root-secret",
+		"endpoint": ff.URL,
+	})
+
+	return b, ff
+}
+
+func testBackend(t *testing.T, s logical.Storage) *backend {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = s
+
+	b := Backend()
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testWriteConfigRoot(t *testing.T, b *backend, s logical.Storage, data map[string]any) {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/root",
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: config writing failed: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+// addKey stores a key document in the database at db, as if created through
+// the dashboard, and returns its secret.
+func (ff *fakeFauna) addKey(db string, params map[string]any) string {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	doc, err := ff.createKey(params, db)
+	if err != nil {
+		panic(err)
+	}
+	return doc.(map[string]any)["secret"].(string)
+}
+
+// addDatabase creates the database at the slash separated path, e.g.
+// "org/team". Parent databases must already exist.
+func (ff *fakeFauna) addDatabase(path string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	parent, name := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		parent, name = path[:i], path[i+1:]
+	}
+	if _, err := ff.createNamed("database", map[string]any{"name": name}, parent); err != nil {
+		panic(err)
+	}
+}
+
+// addCollection creates the collection name in the database at db.
+func (ff *fakeFauna) addCollection(db, name string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	if _, err := ff.createNamed("collection", map[string]any{"name": name}, db); err != nil {
+		panic(err)
+	}
+}
+
+// addDocument stores a document with the given id and data in collection.
+func (ff *fakeFauna) addRole(db, name string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	if _, err := ff.createNamed("role", map[string]any{"name": name, "privileges": []any{}}, db); err != nil {
+		panic(err)
+	}
+}
+
+func (ff *fakeFauna) addDocument(db, collection, id string, data map[string]any) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	coll := &fakeRef{id: collection, coll: &fakeRef{id: "collections"}, db: db}
+	ref := &fakeRef{id: id, coll: coll, db: db}
+	if _, err := ff.create(ref, map[string]any{"data": data}); err != nil {
+		panic(err)
+	}
+}
+
+// addIndex creates an index on collection whose terms are the given fields
+// of the documents' data.
+func (ff *fakeFauna) addIndex(db, name, collection string, fields ...string) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	terms := make([]any, len(fields))
+	for i, field := range fields {
+		terms[i] = map[string]any{"field": []any{"data", field}}
+	}
+	_, err := ff.createNamed("index", map[string]any{
+		"name":   name,
+		"source": &fakeRef{id: collection, coll: &fakeRef{id: "collections"}, db: db},
+		"terms":  terms,
+	}, db)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// keyDoc returns a copy of the key document for secret, or nil.
+func (ff *fakeFauna) keyDoc(secret string) map[string]any {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	d := ff.keyBySecret(secret)
+	if d == nil {
+		return nil
+	}
+	out := make(map[string]any, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
+
+// keyExists reports whether a key with the given secret exists.
+func (ff *fakeFauna) keyExists(secret string) bool {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	return ff.keyBySecret(secret) != nil
+}
+
+// failingStorage wraps logical.Storage and fails operations whose key has
+// one of the configured prefixes.
+type failingStorage struct {
+	logical.Storage
+
+	mu      sync.Mutex
+	failPut []string
+	failDel []string
+}
+
+func (s *failingStorage) Put(ctx context.Context, e *logical.StorageEntry) error {
+	if s.matches(s.failPut, e.Key) {
+		return fmt.Errorf("injected put failure for %q", e.Key)
+	}
+	return s.Storage.Put(ctx, e)
+}
+
+func (s *failingStorage) Delete(ctx context.Context, key string) error {
+	if s.matches(s.failDel, key) {
+		return fmt.Errorf("injected delete failure for %q", key)
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s *failingStorage) setFailPut(prefixes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPut = prefixes
+}
+
+func (s *failingStorage) setFailDelete(prefixes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failDel = prefixes
+}
+
+func (s *failingStorage) matches(prefixes []string, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
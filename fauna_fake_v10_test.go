@@ -0,0 +1,181 @@
+package fauna
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// fakeBuiltinRolesV10 are the roles v10 keys can have without a user-defined
+// role of that name.
+var fakeBuiltinRolesV10 = map[string]bool{"admin": true, "server": true, "server-readonly": true}
+
+// handleFQL serves the FQL v10 query endpoint. It recognises the queries the
+// backend sends by their text and answers in the simple format.
+func (ff *fakeFauna) handleFQL(w http.ResponseWriter, r *http.Request) {
+	scope, ok := ff.authenticate(r.Header.Get("Authorization"))
+	if !ok {
+		ff.writeErrorV10(w, &fakeError{401, "unauthorized", "Access token required"})
+		return
+	}
+
+	var req struct {
+		Query     string         `json:"query"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		ff.writeErrorV10(w, &fakeError{400, "invalid_request", err.Error()})
+		return
+	}
+	ff.queries = append(ff.queries, req.Query)
+	args, _ := untagged(req.Arguments).(map[string]any)
+
+	res, err := ff.evalFQL(req.Query, args, scope)
+	if err != nil {
+		fe, ok := err.(*fakeError)
+		if !ok {
+			fe = &fakeError{400, "invalid_query", err.Error()}
+		}
+		ff.writeErrorV10(w, fe)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"data":   res,
+		"txn_ts": time.Now().UnixMicro(),
+	})
+}
+
+func (ff *fakeFauna) writeErrorV10(w http.ResponseWriter, fe *fakeError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fe.status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": fe.code, "message": fe.description},
+	})
+}
+
+func (ff *fakeFauna) evalFQL(query string, args map[string]any, scope string) (any, error) {
+	if err := ff.failing(query); err != nil {
+		return nil, err
+	}
+
+	switch query {
+	case "Key.create(params)":
+		params, _ := args["params"].(map[string]any)
+		return ff.createKeyV10(params, scope)
+
+	case "Key.byId(id)?.delete()":
+		ref := &fakeRef{id: args["id"].(string), coll: &fakeRef{id: "keys"}, db: scope}
+		doc, found := ff.docs[ref.key()]
+		if !found {
+			return nil, nil
+		}
+		ff.remove(ref)
+		return renderKeyV10(doc), nil
+
+	case "Key.all().first()":
+		var ids []string
+		for k, d := range ff.docs {
+			if ref, ok := d["ref"].(*fakeRef); ok && ref.class() == "keys" && ref.db == scope {
+				ids = append(ids, k)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		sort.Strings(ids)
+		return renderKeyV10(ff.docs[ids[0]]), nil
+	}
+
+	return nil, &fakeError{400, "invalid_query", "unsupported query " + strconv.Quote(query)}
+}
+
+func (ff *fakeFauna) createKeyV10(params map[string]any, scope string) (any, error) {
+	keyScope := scope
+	if name, ok := params["database"].(string); ok {
+		db := &fakeRef{id: name, coll: &fakeRef{id: "databases"}, db: scope}
+		if _, found := ff.docs[db.key()]; !found {
+			return nil, &fakeError{400, "invalid_argument", "database not found"}
+		}
+		keyScope = db.path()
+	}
+
+	role, _ := params["role"].(string)
+	if !fakeBuiltinRolesV10[role] {
+		roleRef := &fakeRef{id: role, coll: &fakeRef{id: "roles"}, db: keyScope}
+		if _, found := ff.docs[roleRef.key()]; !found {
+			return nil, &fakeError{400, "invalid_argument", "role not found"}
+		}
+	}
+
+	id := ff.newID()
+	ref := &fakeRef{id: id, coll: &fakeRef{id: "keys"}, db: scope}
+	secret := "fnS" + id
+	doc := map[string]any{
+		"ref":      ref,
+		"ts":       json.Number(strconv.FormatInt(time.Now().UnixMicro(), 10)),
+		"role":     role,
+		"__secret": secret,
+		"__scope":  keyScope,
+		"__v10":    true,
+	}
+	for k, v := range params {
+		if k != "role" {
+			doc[k] = v
+		}
+	}
+	ff.docs[ref.key()] = doc
+
+	out := renderKeyV10(doc)
+	out["secret"] = secret
+	return out, nil
+}
+
+// renderKeyV10 returns a key document in the simple format.
+func renderKeyV10(doc map[string]any) map[string]any {
+	out := map[string]any{
+		"id":   doc["ref"].(*fakeRef).id,
+		"coll": "Key",
+		"role": doc["role"],
+	}
+	for _, k := range []string{"database", "data"} {
+		if v, ok := doc[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// untagged decodes a value in the tagged format of the v10 API.
+func untagged(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 1 {
+			for tag, inner := range v {
+				switch tag {
+				case "@int", "@long", "@double":
+					return json.Number(inner.(string))
+				case "@object":
+					return untagged(inner)
+				}
+			}
+		}
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = untagged(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = untagged(item)
+		}
+		return out
+	}
+	return v
+}
@@ -2,9 +2,15 @@ package fauna
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
 )
 
 const faunaKeyType = "fauna_keys"
@@ -26,57 +32,621 @@ func faunaKeys(b *backend) *framework.Secret {
 
 func (b *backend) faunaKeyCreate(
 	ctx context.Context,
-	s logical.Storage,
-	displayName, policyName string,
-	role *FaunaRoleEntry) (*logical.Response, error) {
-	client, err := b.client(ctx, s)
+	req *logical.Request,
+	policyName string,
+	role *FaunaRoleEntry,
+	metadata map[string]string,
+	requestedTTL time.Duration) (*logical.Response, error) {
+	s, displayName := req.Storage, req.DisplayName
+
+	client, err := b.client(ctx, s, role.Connection)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	// Create the keys
-	faunaKey, err := client.createKey(role)
+	// Roles written before these were checked against the connection
+	if client.isAccount() {
+		if errResp := role.checkAccountKey(); errResp != nil {
+			return errResp, nil
+		}
+	}
+
+	// The key's data is the role's extra data plus where the key came from
+	extra, err := b.keyData(req, policyName, role, metadata)
 	if err != nil {
-		return logical.ErrorResponse("Error creating key: %s", err), err
+		return logical.ErrorResponse(err.Error()), nil
 	}
+	keyRole := *role
+	keyRole.Extra = extra
+	role = &keyRole
 
-	refJSON, err := faunaKey.Ref.MarshalJSON()
+	// Work out the lease before creating anything so a bad TTL can't leave a
+	// key behind
+	ttl, maxTTL, warnings, err := b.keyTTL(ctx, s, role, requestedTTL, time.Time{})
 	if err != nil {
-		return logical.ErrorResponse("Error creating key: %s", err), err
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if role.KeyTTLMargin > 0 && (client.isV10() || client.isAccount()) {
+		warnings = append(warnings, "'key_ttl_margin' only applies to keys issued over FQL v4 and was ignored")
+	}
+
+	if client.isV10() {
+		if (role.Type != roleTypeKey && role.Type != "") || role.Privileges != "" {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Role '%s' requires a connection with api_version %s", policyName, apiVersion4)), nil
+		}
+		return b.faunaKeyCreateV10(ctx, s, policyName, client, role, ttl, maxTTL, warnings)
+	}
+	if client.isAccount() {
+		if role.Type != roleTypeDatabase || role.Database != "" || len(role.SchemaStatements) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Role '%s' can't be used with an %s connection, which only creates top-level databases without schema statements",
+				policyName, connectionTypeAccount)), nil
+		}
+		if len(metadata) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Keys issued over an %s connection can't carry metadata", connectionTypeAccount)), nil
+		}
+		name, err := role.databaseName(policyName, displayName)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		return b.faunaKeyCreateAccount(ctx, s, policyName, client, role, name, ttl, maxTTL, warnings)
+	}
+
+	internalData := map[string]any{
+		"role":        policyName,
+		"connection":  role.Connection,
+		"api_version": apiVersion4,
+	}
+
+	// Fauna deletes the key by itself should revocation fail, a margin after
+	// the lease ends
+	var expires time.Time
+	if role.KeyTTLMargin > 0 {
+		expires = time.Now().Add(ttl + role.KeyTTLMargin)
+		internalData["key_ttl_margin"] = role.KeyTTLMargin.String()
+	}
+
+	var faunaKey *FaunaKey
+	var refJSON, walID string
+	if role.Type == roleTypeDatabase {
+		name, err := role.databaseName(policyName, displayName)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		var databaseJSON string
+		faunaKey, refJSON, databaseJSON, walID, err = b.createDatabaseWithWAL(ctx, s, client, role, name, expires)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+		internalData["parent"] = role.Database
+		internalData["database"] = databaseJSON
+	} else if role.Privileges != "" {
+		name, err := role.faunaRoleName(policyName, displayName)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		faunaKey, refJSON, walID, err = b.createFaunaRoleWithWAL(ctx, s, client, role, name, expires)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+		internalData["parent"] = role.Database
+		internalData["fauna_role"] = name
+	} else {
+		// For roles of type "scoped" the key only serves to derive the scoped
+		// secrets from and to revoke them all at once, so it is not returned
+		keyRole := role
+		if role.Type == roleTypeScoped {
+			adminRole := *role
+			adminRole.Role = "admin"
+			adminRole.Database = joinDatabasePath(role.Database, role.scopeRoot())
+			if adminRole.Database == "" {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"Role '%s' would issue an admin key for the connection's database", policyName)), nil
+			}
+			keyRole = &adminRole
+		}
+
+		var keyWAL *walKey
+		faunaKey, keyWAL, walID, err = b.createKeyWithWAL(ctx, s, client, keyRole, expires)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), err
+		}
+		refJSON = keyWAL.Ref
+		internalData["parent"] = keyWAL.Parent
 	}
+	internalData["ref"] = refJSON
 
-	resp := b.Secret(faunaKeyType).Response(map[string]any{
+	data := map[string]any{
+		"secret": faunaKey.Secret,
+	}
+	if role.Type == roleTypeScoped {
+		data = map[string]any{
+			"scoped_secrets": role.scopedSecrets(faunaKey.Secret),
+		}
+	}
+	return b.leaseResponse(ctx, s, faunaKeyType, data, internalData, ttl, maxTTL, warnings, walID)
+}
+
+// leaseResponse returns the response for a new lease of secretType. The
+// lease owns what the "key" WAL entry walID tracks, so the entry is removed
+// to commit it.
+func (b *backend) leaseResponse(
+	ctx context.Context,
+	s logical.Storage,
+	secretType string,
+	data, internalData map[string]any,
+	ttl, maxTTL time.Duration,
+	warnings []string,
+	walID string) (*logical.Response, error) {
+	resp := b.Secret(secretType).Response(data, internalData)
+
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = maxTTL
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return nil, errwrap.Wrapf("error committing WAL entry: {{err}}", err)
+	}
+
+	return resp, nil
+}
+
+// keyData returns the data of a key issued for role: the role's extra data
+// and the name from its name template, both rendered for the request, the
+// metadata the requester passed if the role allows it, and the metadata of
+// the request under "vault". That lets a key found in Fauna
+// be traced back to the request, and through the audit log to its lease,
+// whose ID is only assigned once the key exists.
+func (b *backend) keyData(req *logical.Request, roleName string, role *FaunaRoleEntry, metadata map[string]string) (map[string]any, error) {
+	templates := extraTemplates(role.Extra)
+	if role.NameTemplate != "" {
+		templates = append(templates, role.NameTemplate)
+	}
+	in, err := b.templateInput(req, roleName, templates)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := renderExtra(role.Extra, in)
+	if err != nil {
+		return nil, errwrap.Wrapf("error rendering 'extra': {{err}}", err)
+	}
+
+	if role.NameTemplate != "" {
+		name, err := renderTemplate(role.NameTemplate, in)
+		if err != nil {
+			return nil, errwrap.Wrapf("error rendering 'name_template': {{err}}", err)
+		}
+		data["name"] = name
+	}
+
+	// Requesters add to what the role defines but can't replace it
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !role.metadataKeyAllowed(k) {
+			return nil, fmt.Errorf("metadata key %q is not allowed by role '%s'", k, roleName)
+		}
+		if _, exists := data[k]; exists || k == "vault" {
+			return nil, fmt.Errorf("metadata key %q is already set by role '%s'", k, roleName)
+		}
+		data[k] = metadata[k]
+	}
+
+	data["vault"] = map[string]any{
+		"mount_accessor": req.MountAccessor,
+		"role":           roleName,
+		"display_name":   req.DisplayName,
+		"entity_id":      req.EntityID,
+		"request_id":     req.ID,
+		"issued_at":      time.Now().UTC().Format(time.RFC3339),
+	}
+	return data, nil
+}
+
+// faunaKeyCreateV10 issues a key for a role of type "key" over the Fauna v10
+// API. The lease records the key's ID rather than a v4 ref.
+func (b *backend) faunaKeyCreateV10(
+	ctx context.Context,
+	s logical.Storage,
+	policyName string,
+	client *FaunaClient,
+	role *FaunaRoleEntry,
+	ttl, maxTTL time.Duration,
+	warnings []string) (*logical.Response, error) {
+	var faunaKey *faunaKeyV10
+	var parent string
+	placeholder := &walKey{Connection: role.Connection, APIVersion: apiVersion10}
+	walID, err := b.createWithWAL(ctx, s, placeholder, "key", func() (*walKey, error) {
+		var err error
+		faunaKey, parent, err = client.createKeyV10(role)
+		if err != nil {
+			return nil, err
+		}
+		return &walKey{
+			Connection: role.Connection,
+			Ref:        faunaKey.ID,
+			Parent:     parent,
+			APIVersion: apiVersion10,
+		}, nil
+	})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	return b.leaseResponse(ctx, s, faunaKeyType, map[string]any{
 		"secret": faunaKey.Secret,
 	}, map[string]any{
-		"ref": string(refJSON),
+		"role":        policyName,
+		"connection":  role.Connection,
+		"api_version": apiVersion10,
+		"ref":         faunaKey.ID,
+		"parent":      parent,
+	}, ttl, maxTTL, warnings, walID)
+}
+
+// faunaKeyCreateAccount creates a top-level database called name through the
+// Account API, with a key for it that has the role's built-in role. The lease
+// records the database's path, as deleting the database takes the key with
+// it.
+func (b *backend) faunaKeyCreateAccount(
+	ctx context.Context,
+	s logical.Storage,
+	policyName string,
+	client *FaunaClient,
+	role *FaunaRoleEntry,
+	name string,
+	ttl, maxTTL time.Duration,
+	warnings []string) (*logical.Response, error) {
+	// The database is only tracked once it exists, so that a name that is
+	// already taken never gets the existing database deleted by rollback
+	var path string
+	databaseWAL := &walKey{Connection: role.Connection}
+	databaseWALID, err := b.createWithWAL(ctx, s, databaseWAL, "database", func() (*walKey, error) {
+		var err error
+		path, err = client.createAccountDatabase(name)
+		if err != nil {
+			return nil, err
+		}
+		databaseWAL = &walKey{Connection: role.Connection, AccountDatabase: path}
+		return databaseWAL, nil
 	})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
 
-	lease, err := b.Lease(ctx, s)
-	if err != nil || lease == nil {
-		lease = &configLease{}
+	key, err := client.createAccountKey(path, role.Role)
+	if err != nil {
+		b.rollbackKeyWAL(ctx, s, databaseWALID, databaseWAL)
+		err = errwrap.Wrapf("Error creating key: {{err}}", err)
+		return logical.ErrorResponse(err.Error()), err
 	}
 
-	resp.Secret.TTL = lease.Lease
-	resp.Secret.MaxTTL = lease.LeaseMax
+	return b.leaseResponse(ctx, s, faunaKeyType, map[string]any{
+		"secret":   key.Secret,
+		"database": path,
+	}, map[string]any{
+		"role":             policyName,
+		"connection":       role.Connection,
+		"ref":              key.ID,
+		"account_database": path,
+	}, ttl, maxTTL, warnings, databaseWALID)
+}
+
+// scopedSecrets returns the secrets a role of type "scoped" derives from the
+// secret of its key, which is for the role's scope root, keyed by the entries
+// of the role's scopes.
+func (r *FaunaRoleEntry) scopedSecrets(secret string) map[string]any {
+	root := r.scopeRoot()
+	secrets := make(map[string]any, len(r.Scopes))
+	for _, scope := range r.Scopes {
+		database, role, _ := splitScope(scope)
+		if root != "" {
+			database = strings.TrimPrefix(database, root+"/")
+		}
+		secrets[scope] = scopedSecret(secret, database, role)
+	}
+	return secrets
+}
 
-	return resp, nil
+// createKeyWithWAL creates a Fauna key for role, expiring at expires unless
+// it is zero, and tracks it with a "key" WAL entry. It returns the key, the
+// entry, whose Ref and Parent locate the key, and the ID of the entry, which
+// the caller must delete once the key is owned by a lease or recorded in
+// storage. Until then rollback deletes the key.
+func (b *backend) createKeyWithWAL(ctx context.Context, s logical.Storage, client *FaunaClient, role *FaunaRoleEntry, expires time.Time) (*FaunaKey, *walKey, string, error) {
+	// The key is created in the parent of its database
+	parent, _ := splitDatabasePath(role.Database)
+
+	var faunaKey *FaunaKey
+	keyWAL := &walKey{Connection: role.Connection, Parent: parent}
+	walID, err := b.createWithWAL(ctx, s, keyWAL, "key", func() (*walKey, error) {
+		var err error
+		faunaKey, _, err = client.createKey(role, expires)
+		if err != nil {
+			return nil, err
+		}
+		refJSON, err := faunaKey.Ref.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		keyWAL = &walKey{Connection: role.Connection, Parent: parent, Ref: string(refJSON)}
+		return keyWAL, nil
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return faunaKey, keyWAL, walID, nil
+}
+
+// createWithWAL runs create, which makes something in Fauna and returns the
+// "key" WAL entry that tracks it. Fauna assigns refs and IDs, so until create
+// returns the attempt is tracked by placeholder, written before talking to
+// Fauna. The ID of the tracking entry is returned; the caller must delete it
+// once what it tracks is owned by a lease or recorded in storage. Until then
+// rollback deletes it.
+func (b *backend) createWithWAL(ctx context.Context, s logical.Storage, placeholder *walKey, what string, create func() (*walKey, error)) (string, error) {
+	walID, err := framework.PutWAL(ctx, s, "key", placeholder)
+	if err != nil {
+		return "", errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+
+	tracked, err := create()
+	if err != nil {
+		if walErr := framework.DeleteWAL(ctx, s, walID); walErr != nil {
+			b.Logger().Warn("error deleting WAL entry", "wal_id", walID, "error", walErr)
+		}
+		return "", errwrap.Wrapf(fmt.Sprintf("Error creating %s: {{err}}", what), err)
+	}
+
+	trackedWALID, err := framework.PutWAL(ctx, s, "key", tracked)
+	if err != nil {
+		req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+		if delErr := b.pathKeyRollback(ctx, req, "key", tracked); delErr != nil {
+			b.Logger().Warn("error deleting "+what+" after WAL failure", "error", delErr)
+		}
+		return "", errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+
+	// What was created is now tracked by the second entry, so the placeholder
+	// can go. If this fails both stay and rollback deletes it.
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return "", errwrap.Wrapf("error deleting WAL entry: {{err}}", err)
+	}
+
+	return trackedWALID, nil
+}
+
+// createDatabaseWithWAL creates a child database called name under the
+// role's database, runs the role's schema statements in it and creates a key
+// for it with the role's settings, expiring at expires unless it is zero.
+// The database is tracked by a "key" WAL entry as soon as it exists; the ID
+// of that entry is returned along with the key and the JSON encoded refs of
+// both, and must be deleted by the caller once a lease owns them. Until then
+// rollback deletes the database, which takes the key with it.
+func (b *backend) createDatabaseWithWAL(ctx context.Context, s logical.Storage, client *FaunaClient, role *FaunaRoleEntry, name string, expires time.Time) (*FaunaKey, string, string, string, error) {
+	parent := client.scoped(role.Database)
+
+	databaseWAL := &walKey{Connection: role.Connection, Parent: role.Database}
+	databaseWALID, err := b.createWithWAL(ctx, s, databaseWAL, "database", func() (*walKey, error) {
+		databaseRef, err := parent.createDatabase(name)
+		if err != nil {
+			return nil, err
+		}
+		databaseJSON, err := databaseRef.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		databaseWAL = &walKey{
+			Connection: role.Connection,
+			Parent:     role.Database,
+			Database:   string(databaseJSON),
+		}
+		return databaseWAL, nil
+	})
+	if err != nil {
+		return nil, "", "", "", err
+	}
+
+	// Schema documents can't be used in the transaction that creates them,
+	// so each statement runs on its own
+	database := parent.scoped(name)
+	for i, statement := range role.SchemaStatements {
+		if err := database.queryRaw(statement); err != nil {
+			b.rollbackKeyWAL(ctx, s, databaseWALID, databaseWAL)
+			return nil, "", "", "", errwrap.Wrapf(fmt.Sprintf("Error running schema statement %d: {{err}}", i), err)
+		}
+	}
+
+	keyRole := *role
+	keyRole.Database = name
+	faunaKey, _, err := parent.createKey(&keyRole, expires)
+	if err != nil {
+		b.rollbackKeyWAL(ctx, s, databaseWALID, databaseWAL)
+		return nil, "", "", "", errwrap.Wrapf("Error creating key: {{err}}", err)
+	}
+
+	refJSON, err := faunaKey.Ref.MarshalJSON()
+	if err != nil {
+		return nil, "", "", "", errwrap.Wrapf("Error creating key: {{err}}", err)
+	}
+
+	return faunaKey, string(refJSON), databaseWAL.Database, databaseWALID, nil
+}
+
+// createFaunaRoleWithWAL creates a Fauna role called name in the role's
+// database, defined by the role's privileges and membership, and a key in
+// that database bound to it, expiring at expires unless it is zero. Both are
+// tracked by a "key" WAL entry whose ID is returned along with the key and
+// its JSON encoded ref; the caller must delete the entry once a lease owns
+// them. Until then rollback deletes both.
+func (b *backend) createFaunaRoleWithWAL(ctx context.Context, s logical.Storage, client *FaunaClient, role *FaunaRoleEntry, name string, expires time.Time) (*FaunaKey, string, string, error) {
+	database := client.scoped(role.Database)
+
+	// The name is known up front, so the role is tracked before it exists
+	roleWAL := &walKey{
+		Connection: role.Connection,
+		Parent:     role.Database,
+		FaunaRole:  name,
+	}
+	roleWALID, err := framework.PutWAL(ctx, s, "key", roleWAL)
+	if err != nil {
+		return nil, "", "", errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+
+	if err := database.createRole(name, role.Privileges, role.Membership); err != nil {
+		b.rollbackKeyWAL(ctx, s, roleWALID, roleWAL)
+		return nil, "", "", errwrap.Wrapf("Error creating Fauna role: {{err}}", err)
+	}
+
+	// The key is created inside the database, where the role is defined
+	keyRole := *role
+	keyRole.Database = ""
+	keyRole.Role = "roles/" + name
+	faunaKey, _, err := database.createKey(&keyRole, expires)
+	if err != nil {
+		b.rollbackKeyWAL(ctx, s, roleWALID, roleWAL)
+		return nil, "", "", errwrap.Wrapf("Error creating key: {{err}}", err)
+	}
+
+	refJSON, err := faunaKey.Ref.MarshalJSON()
+	if err != nil {
+		return nil, "", "", errwrap.Wrapf("Error creating key: {{err}}", err)
+	}
+
+	keyWAL := *roleWAL
+	keyWAL.Ref = string(refJSON)
+	keyWALID, err := framework.PutWAL(ctx, s, "key", &keyWAL)
+	if err != nil {
+		if delErr := database.deleteKey(faunaKey.Ref); delErr != nil {
+			b.Logger().Warn("error deleting key after WAL failure", "ref", string(refJSON), "error", delErr)
+		}
+		b.rollbackKeyWAL(ctx, s, roleWALID, roleWAL)
+		return nil, "", "", errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+
+	// If this fails the tracked entry is left in place and rollback deletes
+	// both the key and the role
+	if err := framework.DeleteWAL(ctx, s, roleWALID); err != nil {
+		return nil, "", "", errwrap.Wrapf("error deleting WAL entry: {{err}}", err)
+	}
+
+	return faunaKey, string(refJSON), keyWALID, nil
+}
+
+// rollbackKeyWAL undoes a failed issuance straight away by running the
+// rollback of its "key" WAL entry, then removes the entry. If the rollback
+// fails the entry stays so that the periodic rollback tries again.
+func (b *backend) rollbackKeyWAL(ctx context.Context, s logical.Storage, walID string, entry *walKey) {
+	req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+	if err := b.pathKeyRollback(ctx, req, "key", entry); err != nil {
+		b.Logger().Warn("error rolling back WAL entry", "wal_id", walID, "error", err)
+		return
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		b.Logger().Warn("error deleting WAL entry", "wal_id", walID, "error", err)
+	}
 }
 
 func (b *backend) faunaKeysRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	lease, err := b.Lease(ctx, req.Storage)
+	// Leases issued before roles carried their own TTLs have no role recorded
+	// and fall back to config/lease, as do leases whose role was deleted
+	var role *FaunaRoleEntry
+	if roleName, ok := req.Secret.InternalData["role"].(string); ok && roleName != "" {
+		var err error
+		role, err = b.roleRead(ctx, req.Storage, roleName, true)
+		if err != nil {
+			return nil, errwrap.Wrapf("error retrieving role: {{err}}", err)
+		}
+	}
+
+	ttl, maxTTL, warnings, err := b.keyTTL(ctx, req.Storage, role, req.Secret.Increment, req.Secret.IssueTime)
 	if err != nil {
-		return nil, err
+		return logical.ErrorResponse(err.Error()), nil
 	}
-	if lease == nil {
-		lease = &configLease{}
+
+	// Keys with a ttl in Fauna keep it a margin past the end of the lease.
+	// If Fauna can't be reached the lease is not renewed either.
+	if marginRaw, ok := req.Secret.InternalData["key_ttl_margin"].(string); ok {
+		if err := b.extendKeyTTL(ctx, req, marginRaw, ttl); err != nil {
+			return nil, errwrap.Wrapf("error extending key ttl: {{err}}", err)
+		}
 	}
 
 	resp := &logical.Response{Secret: req.Secret}
-	resp.Secret.TTL = lease.Lease
-	resp.Secret.MaxTTL = lease.LeaseMax
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = maxTTL
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
 	return resp, nil
 }
 
+// extendKeyTTL sets the ttl of the key of a lease being renewed to ttl plus
+// margin from now.
+func (b *backend) extendKeyTTL(ctx context.Context, req *logical.Request, margin string, ttl time.Duration) error {
+	marginDuration, err := time.ParseDuration(margin)
+	if err != nil {
+		return err
+	}
+
+	var entry walKey
+	if err := mapstructure.Decode(req.Secret.InternalData, &entry); err != nil {
+		return err
+	}
+
+	client, err := b.client(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+	return client.scoped(entry.Parent).setKeyTTL(entry.Ref, time.Now().Add(ttl+marginDuration))
+}
+
+// keyTTL calculates the TTL for a key lease. The requested TTL falls back to
+// the role's TTL and then the config/lease default. It is capped by the role's
+// max TTL, or the config/lease maximum when the role has none, and by the
+// mount's max TTL, counted from issueTime. role may be nil.
+func (b *backend) keyTTL(ctx context.Context, s logical.Storage, role *FaunaRoleEntry, requested time.Duration, issueTime time.Time) (time.Duration, time.Duration, []string, error) {
+	lease, err := b.Lease(ctx, s)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if lease == nil {
+		lease = &configLease{}
+	}
+
+	defaultTTL, roleMaxTTL := lease.Lease, lease.LeaseMax
+	if role != nil && role.TTL > 0 {
+		defaultTTL = role.TTL
+	}
+	if role != nil && role.MaxTTL > 0 {
+		roleMaxTTL = role.MaxTTL
+	}
+
+	maxTTL := b.System().MaxLeaseTTL()
+	if roleMaxTTL > 0 && roleMaxTTL < maxTTL {
+		maxTTL = roleMaxTTL
+	}
+
+	ttl, warnings, err := framework.CalculateTTL(b.System(), requested, defaultTTL, 0, maxTTL, 0, issueTime)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return ttl, maxTTL, warnings, nil
+}
+
 func (b *backend) faunaKeysRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	// Use the key rollback mechanism to delete this key
 	if err := b.pathKeyRollback(ctx, req, "key", req.Secret.InternalData); err != nil {
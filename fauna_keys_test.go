@@ -0,0 +1,1111 @@
+package fauna
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testWriteRole(t *testing.T, b *backend, s logical.Storage, name string, data map[string]any) {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "roles/" + name,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: role writing failed: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func testReadKey(b *backend, s logical.Storage, name string, data map[string]any) (*logical.Response, error) {
+	return b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      name,
+		Data:      data,
+	})
+}
+
+// testRollbackAll runs the WAL rollback for every outstanding entry, the
+// same way the framework does once entries are older than the minimum age.
+func testRollbackAll(t *testing.T, b *backend, s logical.Storage) {
+	t.Helper()
+
+	ctx := context.Background()
+	ids, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		entry, err := framework.GetWAL(ctx, s, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+		if err := b.walRollback(ctx, req, entry.Kind, entry.Data); err != nil {
+			t.Fatalf("rollback of %s failed: %v", id, err)
+		}
+		if err := framework.DeleteWAL(ctx, s, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testWALCount(t *testing.T, s logical.Storage) int {
+	t.Helper()
+
+	ids, err := framework.ListWAL(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ids)
+}
+
+func TestBackend_KeyCreateWAL(t *testing.T) {
+	t.Run("success commits the WAL", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+		resp, err := testReadKey(b, s, "deploy", nil)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+		}
+		if resp.Data["secret"] == "" {
+			t.Fatal("expected a secret in the response")
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+		if n := ff.count("", "keys"); n != 1 {
+			t.Fatalf("expected 1 key in Fauna, got %d", n)
+		}
+	})
+
+	t.Run("Fauna failure leaves no WAL", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+		ff.failNext("create_key", 1)
+		if _, err := testReadKey(b, s, "deploy", nil); err == nil {
+			t.Fatal("expected key creation to fail")
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+		if n := ff.count("", "keys"); n != 0 {
+			t.Fatalf("expected no keys in Fauna, got %d", n)
+		}
+	})
+
+	t.Run("WAL write failure creates no key", func(t *testing.T) {
+		s := &failingStorage{Storage: &logical.InmemStorage{}}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+		s.setFailPut(framework.WALPrefix)
+		if _, err := testReadKey(b, s, "deploy", nil); err == nil {
+			t.Fatal("expected key creation to fail")
+		}
+		if n := ff.count("", "keys"); n != 0 {
+			t.Fatalf("expected no keys in Fauna, got %d", n)
+		}
+	})
+
+	t.Run("commit failure is rolled back", func(t *testing.T) {
+		s := &failingStorage{Storage: &logical.InmemStorage{}}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+		s.setFailDelete(framework.WALPrefix)
+		if _, err := testReadKey(b, s, "deploy", nil); err == nil {
+			t.Fatal("expected key creation to fail")
+		}
+		if n := ff.count("", "keys"); n != 1 {
+			t.Fatalf("expected the orphaned key in Fauna, got %d keys", n)
+		}
+
+		s.setFailDelete()
+		testRollbackAll(t, b, s)
+
+		if n := ff.count("", "keys"); n != 0 {
+			t.Fatalf("expected rollback to delete the orphaned key, got %d keys", n)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+	})
+}
+
+func TestBackend_KeyRevoke(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+	resp, err := testReadKey(b, s, "deploy", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if n := ff.count("", "keys"); n != 0 {
+		t.Fatalf("expected the key to be deleted, got %d keys", n)
+	}
+
+	// Revoking a key that is already gone must succeed so the lease can be
+	// cleaned up.
+	_, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("second revoke failed: %v", err)
+	}
+}
+
+func TestBackend_KeyTTL(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, _ := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/lease",
+		Data:      map[string]any{"lease": "1h", "lease_max": "2h"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: lease writing failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	cases := []struct {
+		name     string
+		data     map[string]any
+		ttl      time.Duration
+		warnings int
+	}{
+		{"default", nil, time.Hour, 0},
+		{"requested", map[string]any{"ttl": "5m"}, 5 * time.Minute, 0},
+		{"clamped", map[string]any{"ttl": "10h"}, 2 * time.Hour, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := testReadKey(b, s, "deploy", tc.data)
+			if err != nil || resp.IsError() {
+				t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+			}
+			if resp.Secret.TTL != tc.ttl {
+				t.Errorf("expected TTL %s, got %s", tc.ttl, resp.Secret.TTL)
+			}
+			if resp.Secret.MaxTTL != 2*time.Hour {
+				t.Errorf("expected max TTL 2h, got %s", resp.Secret.MaxTTL)
+			}
+			if len(resp.Warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %v", tc.warnings, resp.Warnings)
+			}
+		})
+	}
+
+	t.Run("renew is capped", func(t *testing.T) {
+		resp, err := testReadKey(b, s, "deploy", nil)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+		}
+
+		secret := resp.Secret
+		secret.IssueTime = time.Now().Add(-90 * time.Minute)
+		secret.Increment = time.Hour
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Storage:   s,
+			Secret:    secret,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: renew failed: resp:%#v\n err: %v", resp, err)
+		}
+		if resp.Secret.TTL > 30*time.Minute {
+			t.Errorf("expected renewal to be capped at 30m, got %s", resp.Secret.TTL)
+		}
+		if len(resp.Warnings) != 1 {
+			t.Errorf("expected a clamp warning, got %v", resp.Warnings)
+		}
+	})
+}
+
+func TestBackend_RoleTTL(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, _ := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "ci", map[string]any{"role": "server", "ttl": "15m", "max_ttl": "30m"})
+
+	resp, err := testReadKey(b, s, "ci", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if resp.Secret.TTL != 15*time.Minute || resp.Secret.MaxTTL != 30*time.Minute {
+		t.Errorf("expected role TTLs 15m/30m, got %s/%s", resp.Secret.TTL, resp.Secret.MaxTTL)
+	}
+
+	secret := resp.Secret
+	secret.IssueTime = time.Now().Add(-20 * time.Minute)
+	secret.Increment = time.Hour
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret:    secret,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: renew failed: resp:%#v\n err: %v", resp, err)
+	}
+	if resp.Secret.TTL > 10*time.Minute {
+		t.Errorf("expected renewal to be capped by the role at 10m, got %s", resp.Secret.TTL)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "roles/ci",
+		Data:      map[string]any{"ttl": "1h"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected ttl above max_ttl to be rejected: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func TestBackend_KeyTTLMargin(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "ci", map[string]any{"role": "server", "ttl": "15m", "max_ttl": "2h", "key_ttl_margin": "1h"})
+
+	keyExpiry := func(secret string) time.Time {
+		t.Helper()
+		raw, ok := ff.keyDoc(secret)["ttl"].(fakeTime)
+		if !ok {
+			t.Fatalf("expected the key to have a ttl, got %#v", ff.keyDoc(secret))
+		}
+		expires, err := time.Parse(time.RFC3339Nano, string(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return expires
+	}
+
+	resp, err := testReadKey(b, s, "ci", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	secret := resp.Data["secret"].(string)
+	if want, got := time.Now().Add(75*time.Minute), keyExpiry(secret); got.Before(want.Add(-time.Minute)) || got.After(want) {
+		t.Fatalf("expected the key to expire 1h after its 15m lease, at %s, got %s", want, got)
+	}
+
+	lease := resp.Secret
+	lease.IssueTime = time.Now()
+	lease.Increment = time.Hour
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret:    lease,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: renew failed: resp:%#v\n err: %v", resp, err)
+	}
+	if want, got := time.Now().Add(2*time.Hour), keyExpiry(secret); got.Before(want.Add(-time.Minute)) || got.After(want) {
+		t.Fatalf("expected renewal to move the key's ttl to %s, got %s", want, got)
+	}
+
+	// A renewal Fauna doesn't record is refused
+	ff.failNext("update", 1)
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   s,
+		Secret:    lease,
+	}); err == nil {
+		t.Fatal("expected renewal to fail when the ttl can't be moved")
+	}
+
+	// Without a margin keys never expire in Fauna
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+	resp, err = testReadKey(b, s, "deploy", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if doc := ff.keyDoc(resp.Data["secret"].(string)); has(doc, "ttl") {
+		t.Fatalf("expected no ttl on the key, got %#v", doc)
+	}
+}
+
+func TestBackend_KeyMetadata(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "ci", map[string]any{"role": "server", "extra": map[string]any{"team": "payments"}})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:           logical.ReadOperation,
+		Storage:             s,
+		Path:                "ci",
+		ID:                  "request-id",
+		MountAccessor:       "fauna_1234",
+		DisplayName:         "token-ci",
+		EntityID:            "entity-id",
+		ClientTokenAccessor: "token-accessor",
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	data, _ := ff.keyDoc(resp.Data["secret"].(string))["data"].(map[string]any)
+	if data["team"] != "payments" {
+		t.Fatalf("expected the role's extra data on the key, got %#v", data)
+	}
+	metadata, _ := data["vault"].(map[string]any)
+	for k, want := range map[string]string{
+		"mount_accessor": "fauna_1234",
+		"role":           "ci",
+		"display_name":   "token-ci",
+		"entity_id":      "entity-id",
+		"request_id":     "request-id",
+	} {
+		if metadata[k] != want {
+			t.Errorf("expected %s %q in the key's metadata, got %#v", k, want, metadata[k])
+		}
+	}
+	// Anyone who can read the key in Fauna could use a token accessor
+	if _, ok := metadata["token_accessor"]; ok {
+		t.Errorf("expected no token accessor in the key's metadata, got %#v", metadata)
+	}
+	if _, err := time.Parse(time.RFC3339, metadata["issued_at"].(string)); err != nil {
+		t.Errorf("expected issued_at to be a timestamp: %v", err)
+	}
+
+	// The stored role keeps only its own extra data
+	role, err := b.roleRead(context.Background(), s, "ci", true)
+	if err != nil || has(role.Extra, "vault") {
+		t.Fatalf("expected the role to be unchanged, got %#v, %v", role, err)
+	}
+}
+
+func TestBackend_KeyTemplates(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "ci", map[string]any{
+		"role":          "server",
+		"name_template": "{{.RoleName}}-{{.DisplayName}}-{{random 8}}",
+		"extra": map[string]any{
+			"team":   "{{identity.entity.metadata.team}}",
+			"owner":  "{{- identity.entity.name | lowercase }}",
+			"static": "kept",
+			"labels": []any{"{{.RoleName}}", "ci"},
+		},
+	})
+
+	entity := &logical.Entity{
+		ID:       "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+		Name:     "Alice",
+		Metadata: map[string]string{"team": "{{.RoleName}}"},
+	}
+	b.System().(*logical.StaticSystemView).EntityVal = entity
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:   logical.ReadOperation,
+		Storage:     s,
+		Path:        "ci",
+		DisplayName: "token-ci",
+		EntityID:    entity.ID,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	data, _ := ff.keyDoc(resp.Data["secret"].(string))["data"].(map[string]any)
+	if name, _ := data["name"].(string); !strings.HasPrefix(name, "ci-token-ci-") || len(name) != len("ci-token-ci-")+8 {
+		t.Errorf("expected a rendered name, got %q", name)
+	}
+	// Identity values are inserted as they are, never rendered themselves
+	if data["team"] != "{{.RoleName}}" {
+		t.Errorf("expected the entity's team, got %#v", data["team"])
+	}
+	if data["owner"] != "alice" {
+		t.Errorf("expected identity values to be piped to functions, got %#v", data["owner"])
+	}
+	if data["static"] != "kept" {
+		t.Errorf("expected plain values to be kept, got %#v", data["static"])
+	}
+	if labels, _ := data["labels"].([]any); len(labels) != 2 || labels[0] != "ci" {
+		t.Errorf("expected templates in arrays to be rendered, got %#v", data["labels"])
+	}
+
+	// The stored role keeps its templates
+	role, err := b.roleRead(context.Background(), s, "ci", true)
+	if err != nil || role.Extra["team"] != "{{identity.entity.metadata.team}}" {
+		t.Fatalf("expected the role's templates to be unchanged, got %#v, %v", role, err)
+	}
+
+	// Identity directives need an entity
+	if resp, err := testReadKey(b, s, "ci", nil); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a request without an entity: resp:%#v\n err: %v", resp, err)
+	}
+
+	for _, data := range []map[string]any{
+		{"role": "server", "name_template": "{{.RoleName"},
+		{"role": "server", "extra": map[string]any{"team": "{{identity.unknown.team}}"}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "roles/invalid",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("expected an invalid template to be rejected: %#v: resp:%#v\n err: %v", data, resp, err)
+		}
+	}
+}
+
+func TestBackend_KeyRequestMetadata(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	testWriteRole(t, b, s, "ci", map[string]any{
+		"role":                  "server",
+		"extra":                 map[string]any{"team": "payments"},
+		"allowed_metadata_keys": []string{"build_*", "tenant", "team"},
+		"denied_metadata_keys":  []string{"build_secret*"},
+	})
+
+	resp, err := testReadKey(b, s, "ci", map[string]any{
+		"metadata": map[string]any{"build_id": "1234", "tenant": "acme"},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	data, _ := ff.keyDoc(resp.Data["secret"].(string))["data"].(map[string]any)
+	if data["build_id"] != "1234" || data["tenant"] != "acme" || data["team"] != "payments" {
+		t.Fatalf("expected the metadata merged into the key's data, got %#v", data)
+	}
+
+	for name, metadata := range map[string]map[string]any{
+		"not allowed":   {"owner": "mallory"},
+		"denied":        {"build_secret_token": "x"},
+		"set by role":   {"team": "other"},
+		"vault's own":   {"vault": "x"},
+		"one of a pair": {"build_id": "1234", "owner": "mallory"},
+	} {
+		resp, err := testReadKey(b, s, "ci", map[string]any{"metadata": metadata})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected the metadata to be rejected: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+	if n := ff.count("", "keys"); n != 1 {
+		t.Fatalf("expected no keys for rejected metadata, got %d keys in all", n)
+	}
+
+	// Without an allowlist requesters can't add anything
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+	if resp, err := testReadKey(b, s, "deploy", map[string]any{"metadata": map[string]any{"build_id": "1234"}}); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected metadata to be rejected: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func TestBackend_RequestedDatabase(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	for _, database := range []string{"tenants", "tenants/acme", "tenant-acme", "tenant-globex", "other"} {
+		ff.addDatabase(database)
+	}
+	testWriteRole(t, b, s, "app", map[string]any{
+		"role":              "server",
+		"database":          "other",
+		"allowed_databases": []string{"tenants/*", "tenant-{{identity.entity.metadata.tenant}}"},
+	})
+
+	entity := &logical.Entity{ID: "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9", Metadata: map[string]string{"tenant": "acme"}}
+	b.System().(*logical.StaticSystemView).EntityVal = entity
+
+	read := func(database, entityID string) *logical.Response {
+		t.Helper()
+		data := map[string]any{}
+		if database != "" {
+			data["database"] = database
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "app",
+			Data:      data,
+			EntityID:  entityID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for database, entityID := range map[string]string{
+		"":             "",
+		"tenants/acme": "",
+		"tenant-acme":  entity.ID,
+	} {
+		resp := read(database, entityID)
+		if resp == nil || resp.IsError() {
+			t.Fatalf("%q: bad: key creation failed: resp:%#v", database, resp)
+		}
+		want := database
+		if want == "" {
+			want = "other"
+		}
+		if key := ff.keyDoc(resp.Data["secret"].(string)); key["__scope"] != want {
+			t.Errorf("%q: expected a key for %s, got %#v", database, want, key)
+		}
+	}
+
+	for database, entityID := range map[string]string{
+		"tenant-globex": entity.ID,
+		"tenant-acme":   "",
+		"other/nested":  entity.ID,
+		"tenants//acme": entity.ID,
+	} {
+		if resp := read(database, entityID); resp == nil || !resp.IsError() {
+			t.Errorf("%q: expected the database to be refused: resp:%#v", database, resp)
+		}
+	}
+
+	// Identity values can't widen the allowlist
+	entity.Metadata["tenant"] = "*"
+	if resp := read("tenant-globex", entity.ID); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a glob from entity metadata to match nothing: resp:%#v", resp)
+	}
+
+	// JWTs aren't issued for a database a requester can pick
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "roles/j",
+		Data:      map[string]any{"type": "jwt", "access_provider": "vault", "allowed_databases": []string{"tenants/*"}},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for allowed_databases on a jwt role: resp:%#v\n err: %v", resp, err)
+	}
+
+	// Roles without an allowlist keep their database
+	testWriteRole(t, b, s, "fixed", map[string]any{"role": "server", "database": "other"})
+	if resp, err := testReadKey(b, s, "fixed", map[string]any{"database": "tenants/acme"}); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the database to be refused: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func TestBackend_DatabaseRole(t *testing.T) {
+	revoke := func(t *testing.T, b *backend, s logical.Storage, resp *logical.Response) {
+		t.Helper()
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		if err != nil {
+			t.Fatalf("revoke failed: %v", err)
+		}
+	}
+
+	t.Run("issue and revoke", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		ff.addDatabase("envs")
+		testWriteRole(t, b, s, "ci", map[string]any{
+			"type":     "database",
+			"database": "envs",
+			"role":     "server",
+		})
+
+		first, err := testReadKey(b, s, "ci", nil)
+		if err != nil || first.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", first, err)
+		}
+		second, err := testReadKey(b, s, "ci", nil)
+		if err != nil || second.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", second, err)
+		}
+		if n := ff.count("envs", "databases"); n != 2 {
+			t.Fatalf("expected a database per key, got %d", n)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+
+		names := ff.ids("envs", "databases")
+		for _, name := range names {
+			if !strings.HasPrefix(name, "vault-ci-") {
+				t.Errorf("expected the default name template, got %q", name)
+			}
+		}
+		secret := first.Data["secret"].(string)
+		if scope := ff.keyDoc(secret)["__scope"]; scope != "envs/"+names[0] && scope != "envs/"+names[1] {
+			t.Fatalf("expected the key to be scoped to a new database, got %v", scope)
+		}
+
+		revoke(t, b, s, first)
+		if ff.keyExists(secret) {
+			t.Fatal("expected the key to be deleted")
+		}
+		if n := ff.count("envs", "databases"); n != 1 {
+			t.Fatalf("expected the database to be deleted, got %d", n)
+		}
+
+		// A database deleted by hand must not block the lease from ending
+		ff.remove(&fakeRef{id: ff.ids("envs", "databases")[0], coll: &fakeRef{id: "databases"}, db: "envs"})
+		revoke(t, b, s, second)
+	})
+
+	t.Run("name template", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "ci", map[string]any{
+			"type":                   "database",
+			"role":                   "admin",
+			"database_name_template": `{{ printf "%s.%s" .RoleName .DisplayName }}`,
+		})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:   logical.ReadOperation,
+			Storage:     s,
+			Path:        "ci",
+			DisplayName: "token",
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+		}
+		if ids := ff.ids("", "databases"); len(ids) != 1 || ids[0] != "ci-token" {
+			t.Fatalf("expected a database named ci-token, got %v", ids)
+		}
+	})
+
+	t.Run("key failure deletes the database", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "ci", map[string]any{"type": "database", "role": "server"})
+
+		ff.failNext("create_key", 1)
+		if _, err := testReadKey(b, s, "ci", nil); err == nil {
+			t.Fatal("expected key creation to fail")
+		}
+		if n := ff.count("", "databases"); n != 0 {
+			t.Fatalf("expected no databases, got %d", n)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+	})
+
+	t.Run("commit failure is rolled back", func(t *testing.T) {
+		s := &failingStorage{Storage: &logical.InmemStorage{}}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "ci", map[string]any{"type": "database", "role": "server"})
+
+		s.setFailDelete(framework.WALPrefix)
+		if _, err := testReadKey(b, s, "ci", nil); err == nil {
+			t.Fatal("expected key creation to fail")
+		}
+
+		s.setFailDelete()
+		testRollbackAll(t, b, s)
+
+		if n := ff.count("", "databases"); n != 0 {
+			t.Fatalf("expected rollback to delete the database, got %d", n)
+		}
+		if n := ff.count("", "keys"); n != 0 {
+			t.Fatalf("expected the database's key to go with it, got %d keys", n)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, _ := testBackendWithFauna(t, s)
+
+		for name, data := range map[string]map[string]any{
+			"unknown type": {"type": "collection", "role": "server"},
+			"bad template": {"type": "database", "role": "server", "database_name_template": "{{ .Nope"},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Storage:   s,
+				Path:      "roles/bad",
+				Data:      data,
+			})
+			if err != nil || resp == nil || !resp.IsError() {
+				t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+			}
+		}
+	})
+}
+
+func TestBackend_DatabaseRoleSchema(t *testing.T) {
+	statements := []string{
+		`{"create_collection": {"object": {"name": "users"}}}`,
+		`{"create_index": {"object": {"name": "users_by_email", "source": {"collection": "users"}}}}`,
+		`{"create_role": {"object": {"name": "reader", "privileges": [{"object": {"resource": {"collection": "users"}, "actions": {"object": {"read": true}}}}]}}}`,
+	}
+
+	t.Run("seeds the database", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "ci", map[string]any{
+			"type":              "database",
+			"role":              "roles/reader",
+			"schema_statements": statements,
+		})
+
+		resp, err := testReadKey(b, s, "ci", nil)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+		}
+
+		db := ff.ids("", "databases")[0]
+		for class, n := range map[string]int{"collections": 1, "indexes": 1, "roles": 1} {
+			if got := ff.count(db, class); got != n {
+				t.Errorf("expected %d %s in the new database, got %d", n, class, got)
+			}
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+	})
+
+	t.Run("failed statement deletes the database", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "ci", map[string]any{
+			"type":              "database",
+			"role":              "server",
+			"schema_statements": statements[:2],
+		})
+
+		ff.failNext("create_index", 1)
+		resp, err := testReadKey(b, s, "ci", nil)
+		if err == nil {
+			t.Fatalf("expected key creation to fail: resp:%#v", resp)
+		}
+		if !strings.Contains(err.Error(), "schema statement 1") {
+			t.Errorf("expected the failing statement in the error, got %v", err)
+		}
+		if n := ff.count("", "databases"); n != 0 {
+			t.Fatalf("expected the database to be rolled back, got %d", n)
+		}
+		if n := ff.count("", "keys"); n != 0 {
+			t.Fatalf("expected no keys, got %d", n)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, _ := testBackendWithFauna(t, s)
+
+		for name, data := range map[string]map[string]any{
+			"invalid JSON": {"type": "database", "role": "server", "schema_statements": []string{"CreateCollection({name: 'users'})"}},
+			"key role":     {"type": "key", "role": "server", "schema_statements": statements},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Storage:   s,
+				Path:      "roles/bad",
+				Data:      data,
+			})
+			if err != nil || resp == nil || !resp.IsError() {
+				t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+			}
+		}
+	})
+}
+
+func TestBackend_DynamicFaunaRole(t *testing.T) {
+	privileges := `[{"object": {"resource": {"collection": "users"}, "actions": {"object": {"read": true}}}}]`
+
+	t.Run("creates a role per key", func(t *testing.T) {
+		ctx := context.Background()
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		ff.addDatabase("app")
+		ff.addCollection("app", "users")
+
+		testWriteRole(t, b, s, "reader", map[string]any{
+			"database":   "app",
+			"privileges": privileges,
+		})
+
+		resp, err := testReadKey(b, s, "reader", nil)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+		}
+		if _, err := testReadKey(b, s, "reader", nil); err != nil {
+			t.Fatalf("bad: key creation failed: %v", err)
+		}
+
+		roles := ff.ids("app", "roles")
+		if len(roles) != 2 {
+			t.Fatalf("expected a Fauna role per key, got %d", len(roles))
+		}
+		name := resp.Secret.InternalData["fauna_role"].(string)
+		if !strings.HasPrefix(name, "vault-reader-") {
+			t.Fatalf("unexpected Fauna role name %q", name)
+		}
+		if ff.doc("app", "roles", name)["privileges"] == nil {
+			t.Fatal("expected the Fauna role to have the role's privileges")
+		}
+		key := ff.keyDoc(resp.Data["secret"].(string))
+		if key == nil || key["role"].(*fakeRef).id != name {
+			t.Fatalf("expected the key to be bound to %s, got %#v", name, key)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+
+		_, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		if err != nil {
+			t.Fatalf("revoke failed: %v", err)
+		}
+		if ff.keyExists(resp.Data["secret"].(string)) {
+			t.Fatal("expected the key to be deleted")
+		}
+		if ff.doc("app", "roles", name) != nil {
+			t.Fatal("expected the Fauna role to be deleted")
+		}
+		if n := ff.count("app", "roles"); n != 1 {
+			t.Fatalf("expected the other key's role to be kept, got %d roles", n)
+		}
+	})
+
+	t.Run("failed key deletes the role", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, ff := testBackendWithFauna(t, s)
+		testWriteRole(t, b, s, "reader", map[string]any{"privileges": privileges})
+
+		ff.addCollection("", "users")
+		ff.failNext("create_key", 1)
+		if resp, err := testReadKey(b, s, "reader", nil); err == nil {
+			t.Fatalf("expected key creation to fail: resp:%#v", resp)
+		}
+		if n := ff.count("", "roles"); n != 0 {
+			t.Fatalf("expected the Fauna role to be rolled back, got %d", n)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		b, _ := testBackendWithFauna(t, s)
+
+		for name, data := range map[string]map[string]any{
+			"role and privileges": {"role": "server", "privileges": privileges},
+			"not an array":        {"privileges": `{"resource": {"collection": "users"}}`},
+			"membership only":     {"role": "server", "membership": `[]`},
+			"database type":       {"type": "database", "privileges": privileges},
+			"invalid membership":  {"privileges": privileges, "membership": "Collection('users')"},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Storage:   s,
+				Path:      "roles/bad",
+				Data:      data,
+			})
+			if err != nil || resp == nil || !resp.IsError() {
+				t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+			}
+		}
+	})
+}
+
+func TestBackend_NestedDatabase(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	for _, db := range []string{"org", "org/team", "org/team/app"} {
+		ff.addDatabase(db)
+	}
+	ff.addRole("org/team/app", "reader")
+
+	testWriteRole(t, b, s, "app", map[string]any{
+		"database": "/org/team/app/",
+		"role":     "roles/reader",
+	})
+	resp, err := testReadKey(b, s, "app", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	key := ff.keyDoc(resp.Data["secret"].(string))
+	if key["__scope"] != "org/team/app" {
+		t.Fatalf("expected a key for org/team/app, got %v", key["__scope"])
+	}
+	if role := key["role"].(*fakeRef); role.db != "org/team/app" {
+		t.Fatalf("expected the role to resolve in org/team/app, got %q", role.db)
+	}
+	if n := ff.count("org/team", "keys"); n != 1 {
+		t.Fatalf("expected the key to be created in org/team, got %d keys there", n)
+	}
+	if resp.Secret.InternalData["parent"] != "org/team" {
+		t.Fatalf("expected the lease to record the key's parent, got %#v", resp.Secret.InternalData)
+	}
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	}); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if ff.keyExists(resp.Data["secret"].(string)) {
+		t.Fatal("expected the key to be deleted on revocation")
+	}
+
+	// Static roles delete their nested keys too
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "static-roles/legacy",
+		Data:      map[string]any{"role": "server", "database": "org/team/app", "rotation_period": "1h"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: static role writing failed: resp:%#v\n err: %v", resp, err)
+	}
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Storage:   s,
+		Path:      "static-roles/legacy",
+	}); err != nil {
+		t.Fatalf("static role deletion failed: %v", err)
+	}
+	if n := ff.count("org/team", "keys"); n != 0 {
+		t.Fatalf("expected the static role's key to be deleted, got %d keys", n)
+	}
+
+	// Roles of type "database" create the child under the nested parent
+	testWriteRole(t, b, s, "ci", map[string]any{
+		"type":     "database",
+		"database": "org/team",
+		"role":     "admin",
+	})
+	if resp, err := testReadKey(b, s, "ci", nil); err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if n := ff.count("org/team", "databases"); n != 2 {
+		t.Fatalf("expected a new database under org/team, got %d databases", n)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "roles/bad",
+		Data:      map[string]any{"database": "org//app", "role": "server"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an empty path segment: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func TestBackend_ScopedRole(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	for _, db := range []string{"org", "org/a", "org/b", "org/b/app"} {
+		ff.addDatabase(db)
+	}
+
+	testWriteRole(t, b, s, "jobs", map[string]any{
+		"type":     "scoped",
+		"database": "org",
+		"scopes":   []string{"a:server", "b/app:roles/reader"},
+	})
+
+	resp, err := testReadKey(b, s, "jobs", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if _, ok := resp.Data["secret"]; ok {
+		t.Fatal("expected the intermediate key's secret not to be returned")
+	}
+	if n := ff.count("", "keys"); n != 1 {
+		t.Fatalf("expected a single key per lease, got %d", n)
+	}
+
+	secrets := resp.Data["scoped_secrets"].(map[string]any)
+	if len(secrets) != 2 {
+		t.Fatalf("expected 2 scoped secrets, got %#v", secrets)
+	}
+	secret := strings.SplitN(secrets["a:server"].(string), ":", 2)[0]
+	if key := ff.keyDoc(secret); key == nil || key["role"] != "admin" || key["__scope"] != "org" {
+		t.Fatalf("expected an admin key for org, got %#v", key)
+	}
+	if got := secrets["b/app:roles/reader"]; got != secret+":b/app:@role/reader" {
+		t.Fatalf("unexpected scoped secret %v", got)
+	}
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if ff.keyExists(secret) {
+		t.Fatal("expected the intermediate key to be deleted")
+	}
+
+	// The key is for the narrowest parent of the scopes, not the connection's
+	// own database, as scoped secrets give it away
+	testWriteRole(t, b, s, "deep", map[string]any{
+		"type":   "scoped",
+		"scopes": []string{"org/a:server", "org/b/app:roles/reader"},
+	})
+	resp, err = testReadKey(b, s, "deep", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	secrets = resp.Data["scoped_secrets"].(map[string]any)
+	secret = strings.SplitN(secrets["org/a:server"].(string), ":", 2)[0]
+	if key := ff.keyDoc(secret); key == nil || key["__scope"] != "org" {
+		t.Fatalf("expected an admin key for org, got %#v", key)
+	}
+	if secrets["org/a:server"] != secret+":a:server" || secrets["org/b/app:roles/reader"] != secret+":b/app:@role/reader" {
+		t.Fatalf("expected scopes relative to org, got %#v", secrets)
+	}
+
+	for name, data := range map[string]map[string]any{
+		"no scopes":      {"type": "scoped"},
+		"no role":        {"type": "scoped", "scopes": []string{"a"}},
+		"no database":    {"type": "scoped", "scopes": []string{":server"}},
+		"non-admin key":  {"type": "scoped", "role": "server", "scopes": []string{"a:server"}},
+		"key role scope": {"type": "key", "role": "server", "scopes": []string{"a:server"}},
+		"top-level":      {"type": "scoped", "scopes": []string{"a:server"}},
+		"no common root": {"type": "scoped", "scopes": []string{"a:server", "b/app:server"}},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "roles/bad",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package fauna
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const faunaTokenType = "fauna_tokens"
+
+func faunaTokens(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: faunaTokenType,
+		Fields: map[string]*framework.FieldSchema{
+			"secret": {
+				Type:        framework.TypeString,
+				Description: "Token Secret",
+			},
+		},
+
+		// Tokens are leased and cleaned up the same way as keys
+		Renew:  b.faunaKeysRenew,
+		Revoke: b.faunaKeysRevoke,
+	}
+}
+
+func (b *backend) faunaTokenCreate(
+	ctx context.Context,
+	req *logical.Request,
+	policyName string,
+	role *FaunaRoleEntry,
+	requestedTTL time.Duration) (*logical.Response, error) {
+	s, entityID := req.Storage, req.EntityID
+
+	var entity map[string]any
+	if role.EntityIndex != "" {
+		if entityID == "" {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Role '%s' issues tokens for Vault entities and the request has no entity", policyName)), nil
+		}
+		info, err := b.System().EntityInfo(entityID)
+		if err != nil {
+			return nil, errwrap.Wrapf("error looking up entity: {{err}}", err)
+		}
+		if info == nil {
+			return logical.ErrorResponse(fmt.Sprintf("Entity '%s' not found", entityID)), nil
+		}
+		entity = entityDocumentData(info)
+	}
+
+	// The token's data is the role's extra data, rendered as it is for keys
+	if role.Extra != nil {
+		in, err := b.templateInput(req, policyName, extraTemplates(role.Extra))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		extra, err := renderExtra(role.Extra, in)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("error rendering 'extra': %s", err)), nil
+		}
+		tokenRole := *role
+		tokenRole.Extra = extra
+		role = &tokenRole
+	}
+
+	client, err := b.client(ctx, s, role.Connection)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := client.requireV4("Token roles"); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	ttl, maxTTL, warnings, err := b.keyTTL(ctx, s, role, requestedTTL, time.Time{})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if role.KeyTTLMargin > 0 {
+		warnings = append(warnings, "'key_ttl_margin' only applies to keys, not tokens, and was ignored")
+	}
+
+	// Tokens live in the database of the document they belong to
+	database := client.scoped(role.Database)
+
+	var token *FaunaToken
+	var refJSON []byte
+	placeholder := &walKey{Connection: role.Connection, Parent: role.Database}
+	walID, err := b.createWithWAL(ctx, s, placeholder, "token", func() (*walKey, error) {
+		var err error
+		token, err = database.createToken(role, entityID, entity)
+		if err != nil {
+			return nil, err
+		}
+		refJSON, err = token.Ref.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return &walKey{Connection: role.Connection, Parent: role.Database, Ref: string(refJSON)}, nil
+	})
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), err
+	}
+
+	instance, err := token.Instance.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.leaseResponse(ctx, s, faunaTokenType, map[string]any{
+		"secret":   token.Secret,
+		"instance": string(instance),
+	}, map[string]any{
+		"ref":        string(refJSON),
+		"role":       policyName,
+		"connection": role.Connection,
+		"parent":     role.Database,
+	}, ttl, maxTTL, warnings, walID)
+}
+
+// entityDocumentData returns the data of the Fauna document that represents a
+// Vault entity. vault_entity_id is what the role's entity index must match on.
+func entityDocumentData(entity *logical.Entity) map[string]any {
+	aliases := make([]any, 0, len(entity.Aliases))
+	for _, alias := range entity.Aliases {
+		aliases = append(aliases, map[string]any{
+			"name":           alias.Name,
+			"mount_accessor": alias.MountAccessor,
+			"mount_type":     alias.MountType,
+			"metadata":       stringMapToAny(alias.Metadata),
+		})
+	}
+
+	return map[string]any{
+		"vault_entity_id":   entity.ID,
+		"vault_entity_name": entity.Name,
+		"metadata":          stringMapToAny(entity.Metadata),
+		"aliases":           aliases,
+	}
+}
+
+func stringMapToAny(m map[string]string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+package fauna
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_TokenRole(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+
+	ff.addDatabase("app")
+	ff.addCollection("app", "users")
+	ff.addDocument("app", "users", "101", map[string]any{"email": "alice@example.com"})
+	ff.addDocument("app", "users", "102", map[string]any{"email": "bob@example.com"})
+	ff.addIndex("app", "users_by_email", "users", "email")
+
+	testWriteRole(t, b, s, "alice", map[string]any{
+		"type":        "token",
+		"database":    "app",
+		"collection":  "users",
+		"document_id": "101",
+		// Tokens get no ttl in Fauna
+		"key_ttl_margin": "1h",
+	})
+	testWriteRole(t, b, s, "bob", map[string]any{
+		"type":        "token",
+		"database":    "app",
+		"index":       "users_by_email",
+		"index_terms": []string{"bob@example.com"},
+	})
+
+	for role, id := range map[string]string{"alice": "101", "bob": "102"} {
+		resp, err := testReadKey(b, s, role, nil)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: token creation failed: resp:%#v\n err: %v", resp, err)
+		}
+		if resp.Secret.InternalData["secret_type"] != faunaTokenType {
+			t.Fatalf("expected a %s lease, got %#v", faunaTokenType, resp.Secret.InternalData)
+		}
+		if warned := len(resp.Warnings) > 0; warned != (role == "alice") {
+			t.Fatalf("expected a warning only for the ignored key_ttl_margin, got %v", resp.Warnings)
+		}
+
+		tokens := ff.ids("app", "tokens")
+		if len(tokens) != 1 {
+			t.Fatalf("expected 1 token, got %d", len(tokens))
+		}
+		instance := ff.doc("app", "tokens", tokens[0])["instance"].(*fakeRef)
+		if instance.id != id {
+			t.Fatalf("expected a token for document %s, got %s", id, instance.id)
+		}
+		if n := testWALCount(t, s); n != 0 {
+			t.Fatalf("expected no WAL entries, got %d", n)
+		}
+
+		_, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RevokeOperation,
+			Storage:   s,
+			Secret:    resp.Secret,
+		})
+		if err != nil {
+			t.Fatalf("revoke failed: %v", err)
+		}
+		if n := ff.count("app", "tokens"); n != 0 {
+			t.Fatalf("expected the token to be deleted, got %d", n)
+		}
+	}
+
+	// No matching document
+	testWriteRole(t, b, s, "nobody", map[string]any{
+		"type":        "token",
+		"database":    "app",
+		"index":       "users_by_email",
+		"index_terms": []string{"eve@example.com"},
+	})
+	if _, err := testReadKey(b, s, "nobody", nil); err == nil {
+		t.Fatal("expected token creation to fail without a matching document")
+	}
+	if n := testWALCount(t, s); n != 0 {
+		t.Fatalf("expected no WAL entries, got %d", n)
+	}
+
+	for name, data := range map[string]map[string]any{
+		"no document":       {"type": "token", "collection": "users"},
+		"id and index":      {"type": "token", "collection": "users", "document_id": "1", "index": "users_by_email"},
+		"id, no collection": {"type": "token", "document_id": "1"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "roles/bad",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+}
+
+func TestBackend_TokenRoleEntity(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+
+	ff.addCollection("", "members")
+	ff.addIndex("", "members_by_entity", "members", "vault_entity_id")
+
+	testWriteRole(t, b, s, "member", map[string]any{
+		"type":         "token",
+		"collection":   "members",
+		"entity_index": "members_by_entity",
+		"extra":        map[string]any{"team": "{{identity.entity.metadata.team}}"},
+	})
+
+	entity := &logical.Entity{
+		ID:       "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+		Name:     "alice",
+		Metadata: map[string]string{"team": "payments"},
+		Aliases: []*logical.Alias{
+			{MountType: "userpass", MountAccessor: "auth_userpass_1234", Name: "alice"},
+		},
+	}
+	b.System().(*logical.StaticSystemView).EntityVal = entity
+
+	read := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Storage:   s,
+			Path:      "member",
+			EntityID:  entity.ID,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: token creation failed: resp:%#v\n err: %v", resp, err)
+		}
+		return resp
+	}
+
+	read()
+	members := ff.ids("", "collections/members")
+	if len(members) != 1 {
+		t.Fatalf("expected a document for the entity, got %d", len(members))
+	}
+	data := ff.doc("", "collections/members", members[0])["data"].(map[string]any)
+	if data["vault_entity_id"] != entity.ID || data["vault_entity_name"] != "alice" {
+		t.Fatalf("unexpected entity document: %#v", data)
+	}
+	if data["metadata"].(map[string]any)["team"] != "payments" {
+		t.Fatalf("expected entity metadata in the document, got %#v", data["metadata"])
+	}
+	if aliases := data["aliases"].([]any); len(aliases) != 1 {
+		t.Fatalf("expected the entity's aliases in the document, got %#v", aliases)
+	}
+	tokens := ff.ids("", "tokens")
+	if len(tokens) != 1 {
+		t.Fatalf("expected 1 token, got %d", len(tokens))
+	}
+	if extra := ff.doc("", "tokens", tokens[0])["data"].(map[string]any); extra["team"] != "payments" {
+		t.Fatalf("expected the token's extra to be rendered, got %#v", extra)
+	}
+
+	// A second request updates the same document
+	entity.Metadata["team"] = "risk"
+	read()
+	if members := ff.ids("", "collections/members"); len(members) != 1 {
+		t.Fatalf("expected the entity document to be reused, got %d documents", len(members))
+	}
+	data = ff.doc("", "collections/members", members[0])["data"].(map[string]any)
+	if data["metadata"].(map[string]any)["team"] != "risk" {
+		t.Fatalf("expected the entity document to be updated, got %#v", data["metadata"])
+	}
+	for _, id := range ff.ids("", "tokens") {
+		if instance := ff.doc("", "tokens", id)["instance"].(*fakeRef); instance.id != members[0] {
+			t.Fatalf("expected tokens for the entity document, got %v", instance.id)
+		}
+	}
+
+	resp, err := testReadKey(b, s, "member", nil)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error without an entity: resp:%#v\n err: %v", resp, err)
+	}
+}
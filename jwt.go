@@ -0,0 +1,304 @@
+package fauna
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	jwtKeysPath = "jwt/keys"
+
+	jwtAlgorithm   = "RS256"
+	jwtRSAKeyBits  = 2048
+	defaultJWTTTL  = 15 * time.Minute
+	jwksPath       = "jwks"
+	discoveryPath  = ".well-known/openid-configuration"
+	jwtClaimPrefix = "vault_"
+)
+
+// jwtSigningKey is one of the keys the mount signs JWTs with. Keys that have
+// been rotated out stay published in the JWKS until ExpiresAt, so tokens
+// signed with them can still be verified.
+type jwtSigningKey struct {
+	ID         string    `json:"id"`
+	PrivateKey []byte    `json:"private_key"` // PKCS #1, ASN.1 DER form
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // Zero for the current key
+}
+
+// jwtKeySet holds the signing keys, newest first. The first key is the one
+// new tokens are signed with.
+type jwtKeySet struct {
+	Keys []*jwtSigningKey `json:"keys"`
+}
+
+func newJWTSigningKey() (*jwtSigningKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, jwtRSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &jwtSigningKey{
+		ID:         hex.EncodeToString(id),
+		PrivateKey: x509.MarshalPKCS1PrivateKey(privateKey),
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// NOTE: The caller is required to ensure that b.jwtMutex is at least read locked
+func readJWTKeys(ctx context.Context, s logical.Storage) (*jwtKeySet, error) {
+	entry, err := s.Get(ctx, jwtKeysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys jwtKeySet
+	if entry == nil {
+		return &keys, nil
+	}
+	if err := entry.DecodeJSON(&keys); err != nil {
+		return nil, errwrap.Wrapf("error reading JWT signing keys: {{err}}", err)
+	}
+	return &keys, nil
+}
+
+// NOTE: The caller is required to hold b.jwtMutex for writing
+func writeJWTKeys(ctx context.Context, s logical.Storage, keys *jwtKeySet) error {
+	entry, err := logical.StorageEntryJSON(jwtKeysPath, keys)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// current returns the key new tokens are signed with, or nil.
+func (ks *jwtKeySet) current() *jwtSigningKey {
+	if len(ks.Keys) == 0 {
+		return nil
+	}
+	return ks.Keys[0]
+}
+
+// prune drops rotated keys whose verification period is over and reports
+// whether any were dropped.
+func (ks *jwtKeySet) prune(now time.Time) bool {
+	kept := ks.Keys[:0]
+	for i, key := range ks.Keys {
+		if i > 0 && now.After(key.ExpiresAt) {
+			continue
+		}
+		kept = append(kept, key)
+	}
+	pruned := len(kept) != len(ks.Keys)
+	ks.Keys = kept
+	return pruned
+}
+
+// rotateJWTKey makes a new signing key current. The replaced key keeps being
+// published for the configured verification TTL.
+// NOTE: The caller is required to hold b.jwtMutex for writing
+func rotateJWTKey(ctx context.Context, s logical.Storage, config *jwtConfig) error {
+	keys, err := readJWTKeys(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	key, err := newJWTSigningKey()
+	if err != nil {
+		return errwrap.Wrapf("error generating JWT signing key: {{err}}", err)
+	}
+
+	now := time.Now().UTC()
+	if current := keys.current(); current != nil {
+		current.ExpiresAt = now.Add(config.VerificationTTL)
+	}
+	keys.Keys = append([]*jwtSigningKey{key}, keys.Keys...)
+	keys.prune(now)
+
+	return writeJWTKeys(ctx, s, keys)
+}
+
+// rotateJWTKeyIfDue rotates the signing key once the rotation period has
+// passed, and stops publishing rotated keys whose verification TTL is over.
+func (b *backend) rotateJWTKeyIfDue(ctx context.Context, s logical.Storage) error {
+	b.jwtMutex.Lock()
+	defer b.jwtMutex.Unlock()
+
+	config, err := readJWTConfig(ctx, s)
+	if err != nil || config == nil {
+		return err
+	}
+
+	keys, err := readJWTKeys(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if current := keys.current(); current == nil || !now.Before(current.CreatedAt.Add(config.RotationPeriod)) {
+		b.Logger().Info("rotating JWT signing key")
+		return rotateJWTKey(ctx, s, config)
+	}
+
+	if keys.prune(now) {
+		return writeJWTKeys(ctx, s, keys)
+	}
+	return nil
+}
+
+// jwks returns the public keys in JSON Web Key Set form.
+func (ks *jwtKeySet) jwks() (map[string]any, error) {
+	published := make([]any, 0, len(ks.Keys))
+	for _, key := range ks.Keys {
+		privateKey, err := x509.ParsePKCS1PrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		published = append(published, map[string]any{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": jwtAlgorithm,
+			"kid": key.ID,
+			"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		})
+	}
+	return map[string]any{"keys": published}, nil
+}
+
+// sign returns a compact JWT carrying claims, signed with key.
+func (key *jwtSigningKey) sign(claims map[string]any) (string, error) {
+	privateKey, err := x509.ParsePKCS1PrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]any{"alg": jwtAlgorithm, "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtCreate issues a JWT for a role of type "jwt". The token is not leased;
+// it is only valid until it expires, so its TTL is kept short and capped by
+// how long the signing key stays published.
+func (b *backend) jwtCreate(ctx context.Context, req *logical.Request, roleName string, role *FaunaRoleEntry, requestedTTL time.Duration) (*logical.Response, error) {
+	b.jwtMutex.RLock()
+	defer b.jwtMutex.RUnlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("the JWT issuer is not configured, write config/jwt first"), nil
+	}
+	if role.Audience == "" {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"Role '%s' has no audience, write access-providers/%s or set 'audience'", roleName, roleName)), nil
+	}
+
+	keys, err := readJWTKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	key := keys.current()
+	if key == nil {
+		return nil, fmt.Errorf("no JWT signing key")
+	}
+
+	ttl := requestedTTL
+	if ttl == 0 {
+		ttl = role.TTL
+	}
+	if ttl == 0 {
+		ttl = defaultJWTTTL
+	}
+	var warnings []string
+	if role.MaxTTL > 0 && ttl > role.MaxTTL {
+		ttl = role.MaxTTL
+		warnings = append(warnings, fmt.Sprintf("ttl was capped by the role's max_ttl of %s", role.MaxTTL))
+	}
+	if ttl > config.VerificationTTL {
+		ttl = config.VerificationTTL
+		warnings = append(warnings, fmt.Sprintf("ttl was capped by the issuer's verification_ttl of %s", config.VerificationTTL))
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	claims := map[string]any{
+		"iss":                        config.Issuer,
+		"aud":                        role.Audience,
+		"sub":                        req.DisplayName,
+		"iat":                        now.Unix(),
+		"nbf":                        now.Unix(),
+		"exp":                        expiresAt.Unix(),
+		"jti":                        hex.EncodeToString(jti),
+		jwtClaimPrefix + "role":      roleName,
+		jwtClaimPrefix + "mount":     strings.TrimSuffix(req.MountPoint, "/"),
+		jwtClaimPrefix + "entity_id": req.EntityID,
+	}
+
+	if req.EntityID != "" {
+		entity, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return nil, errwrap.Wrapf("error looking up entity: {{err}}", err)
+		}
+		claims["sub"] = req.EntityID
+		if entity != nil {
+			claims[jwtClaimPrefix+"entity_name"] = entity.Name
+			claims[jwtClaimPrefix+"entity_metadata"] = entity.Metadata
+		}
+	}
+
+	token, err := key.sign(claims)
+	if err != nil {
+		return nil, errwrap.Wrapf("error signing JWT: {{err}}", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"token":      token,
+			"expires_at": expiresAt.Format(time.RFC3339),
+			"ttl":        int64(ttl.Seconds()),
+		},
+		Warnings: warnings,
+	}, nil
+}
@@ -0,0 +1,269 @@
+package fauna
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// testReadRawJSON reads an unauthenticated path and decodes its raw body.
+func testReadRawJSON(t *testing.T, b *backend, s logical.Storage, path string) map[string]any {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      path,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: reading %s failed: resp:%#v\n err: %v", path, resp, err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// testVerifyJWT checks the signature of token against the published keys
+// and returns its header and claims.
+func testVerifyJWT(t *testing.T, token string, jwks map[string]any) (map[string]any, map[string]any) {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed JWT %q", token)
+	}
+	decode := func(part string) map[string]any {
+		raw, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]any
+		if err := json.Unmarshal(raw, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	header, claims := decode(parts[0]), decode(parts[1])
+
+	for _, raw := range jwks["keys"].([]any) {
+		jwk := raw.(map[string]any)
+		if jwk["kid"] != header["kid"] {
+			continue
+		}
+		n, _ := base64.RawURLEncoding.DecodeString(jwk["n"].(string))
+		e, _ := base64.RawURLEncoding.DecodeString(jwk["e"].(string))
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatalf("bad signature: %v", err)
+		}
+		return header, claims
+	}
+
+	t.Fatalf("signing key %v is not published", header["kid"])
+	return nil, nil
+}
+
+func TestBackend_JWT(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	ff.addDatabase("app")
+
+	testWriteRole(t, b, s, "app", map[string]any{
+		"type":           "jwt",
+		"database":       "app",
+		"provider_roles": []string{"roles/reader"},
+	})
+
+	resp, err := testReadKey(b, s, "app", nil)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error without an issuer: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/jwt",
+		Data:      map[string]any{"issuer": "https://vault.example.com/v1/fauna/"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: issuer config failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "config/jwt",
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: issuer config reading failed: resp:%#v\n err: %v", resp, err)
+	}
+	if _, ok := resp.Data["rotation_period"].(int64); !ok {
+		t.Fatalf("expected rotation_period in whole seconds, got %#v", resp.Data["rotation_period"])
+	}
+	if _, ok := resp.Data["verification_ttl"].(int64); !ok {
+		t.Fatalf("expected verification_ttl in whole seconds, got %#v", resp.Data["verification_ttl"])
+	}
+
+	discovery := testReadRawJSON(t, b, s, discoveryPath)
+	if discovery["issuer"] != "https://vault.example.com/v1/fauna" ||
+		discovery["jwks_uri"] != "https://vault.example.com/v1/fauna/jwks" {
+		t.Fatalf("unexpected discovery document: %#v", discovery)
+	}
+	jwks := testReadRawJSON(t, b, s, jwksPath)
+	if n := len(jwks["keys"].([]any)); n != 1 {
+		t.Fatalf("expected 1 published key, got %d", n)
+	}
+
+	resp, err = testReadKey(b, s, "app", nil)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error without an audience: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "access-providers/app",
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: access provider writing failed: resp:%#v\n err: %v", resp, err)
+	}
+	audience := resp.Data["audience"].(string)
+	provider := ff.doc("app", "access_providers", "vault-app")
+	if provider == nil || provider["jwks_uri"] != "https://vault.example.com/v1/fauna/jwks" {
+		t.Fatalf("unexpected access provider: %#v", provider)
+	}
+	if roles := provider["roles"].([]any); len(roles) != 1 || roles[0].(*fakeRef).id != "reader" {
+		t.Fatalf("expected the provider to grant the reader role, got %#v", roles)
+	}
+
+	// Writing it again updates the same provider
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "access-providers/app",
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Data["audience"] != audience {
+		t.Fatalf("bad: access provider update failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	entity := &logical.Entity{
+		ID:       "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+		Name:     "alice",
+		Metadata: map[string]string{"team": "payments"},
+	}
+	b.System().(*logical.StaticSystemView).EntityVal = entity
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "app",
+		EntityID:  entity.ID,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: JWT creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if resp.Secret != nil {
+		t.Fatal("expected JWTs not to be leased")
+	}
+	header, claims := testVerifyJWT(t, resp.Data["token"].(string), jwks)
+	if claims["iss"] != "https://vault.example.com/v1/fauna" || claims["aud"] != audience || claims["sub"] != entity.ID {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if claims["vault_entity_name"] != "alice" || claims["vault_entity_metadata"].(map[string]any)["team"] != "payments" {
+		t.Fatalf("expected entity claims, got %#v", claims)
+	}
+	if ttl := claims["exp"].(float64) - claims["iat"].(float64); ttl != defaultJWTTTL.Seconds() {
+		t.Fatalf("expected the default ttl, got %vs", ttl)
+	}
+
+	// The ttl is capped by how long the signing key stays published
+	resp, err = testReadKey(b, s, "app", map[string]any{"ttl": "48h"})
+	if err != nil || resp == nil || resp.IsError() || len(resp.Warnings) == 0 {
+		t.Fatalf("expected a capped ttl: resp:%#v\n err: %v", resp, err)
+	}
+	if ttl := resp.Data["ttl"].(int64); ttl != int64(defaultJWTVerificationTTL.Seconds()) {
+		t.Fatalf("expected the ttl to be capped at verification_ttl, got %ds", ttl)
+	}
+
+	// Rotated keys stay published until their verification_ttl is over
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/jwt/rotate",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: rotation failed: resp:%#v\n err: %v", resp, err)
+	}
+	jwks = testReadRawJSON(t, b, s, jwksPath)
+	if n := len(jwks["keys"].([]any)); n != 2 {
+		t.Fatalf("expected 2 published keys after rotation, got %d", n)
+	}
+	resp, err = testReadKey(b, s, "app", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: JWT creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if rotated, _ := testVerifyJWT(t, resp.Data["token"].(string), jwks); rotated["kid"] == header["kid"] {
+		t.Fatal("expected JWTs to be signed with the new key")
+	}
+
+	keys, err := readJWTKeys(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Keys[1].ExpiresAt = time.Now().Add(-time.Minute)
+	if err := writeJWTKeys(ctx, s, keys); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.rotateJWTKeyIfDue(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(testReadRawJSON(t, b, s, jwksPath)["keys"].([]any)); n != 1 {
+		t.Fatalf("expected the expired key to be pruned, got %d keys", n)
+	}
+
+	// The current key is rotated once the rotation period has passed
+	keys, err = readJWTKeys(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := keys.current().ID
+	keys.Keys[0].CreatedAt = time.Now().Add(-defaultJWTRotationPeriod)
+	if err := writeJWTKeys(ctx, s, keys); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := readJWTKeys(ctx, s); err != nil || keys.current().ID == current || len(keys.Keys) != 2 {
+		t.Fatalf("expected the signing key to be rotated: %#v, %v", keys, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/jwt",
+		Data:      map[string]any{"issuer": "not a url"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an invalid issuer: resp:%#v\n err: %v", resp, err)
+	}
+}
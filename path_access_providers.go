@@ -0,0 +1,98 @@
+package fauna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pathAccessProvidersHelpSyn = `
+Create or update the Fauna access provider of a JWT role.
+`
+
+const pathAccessProvidersHelpDesc = `
+Writing to "access-providers/<role>" creates the access provider that a role
+of type "jwt" issues JWTs for, in the role's database, or updates it to
+trust this mount's issuer and grant the role's "provider_roles". The
+audience Fauna assigns to the provider is stored on the role, and becomes
+the "aud" claim of its JWTs.
+`
+
+func pathAccessProviders(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "access-providers/" + framework.GenericNameWithAtRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathAccessProvidersWrite,
+		},
+
+		HelpSynopsis:    pathAccessProvidersHelpSyn,
+		HelpDescription: pathAccessProvidersHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessProvidersWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	b.jwtMutex.RLock()
+	config, err := readJWTConfig(ctx, req.Storage)
+	b.jwtMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("the JWT issuer is not configured, write config/jwt first"), nil
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	role, err := b.roleRead(ctx, req.Storage, roleName, false)
+	if err != nil {
+		return nil, errwrap.Wrapf("error retrieving role: {{err}}", err)
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' not found", roleName)), nil
+	}
+	if role.Type != roleTypeJWT {
+		return logical.ErrorResponse(fmt.Sprintf("Role '%s' is not of type %q", roleName, roleTypeJWT)), nil
+	}
+
+	client, err := b.client(ctx, req.Storage, role.Connection)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := client.requireV4("Access providers"); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	name := role.accessProviderName(roleName)
+	audience, err := client.scoped(role.Database).upsertAccessProvider(name, config.Issuer, config.jwksURI(), role.ProviderRoles)
+	if err != nil {
+		return nil, errwrap.Wrapf("error writing access provider: {{err}}", err)
+	}
+
+	role.Audience = audience
+	if err := setFaunaRole(ctx, req.Storage, roleName, role); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"name":     name,
+			"database": role.Database,
+			"issuer":   config.Issuer,
+			"jwks_uri": config.jwksURI(),
+			"audience": audience,
+		},
+	}, nil
+}
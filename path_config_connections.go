@@ -0,0 +1,176 @@
+package fauna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pathListConfigConnectionsHelpSyn = `List the named Fauna connections of this backend`
+
+const pathListConfigConnectionsHelpDesc = `Connections will be listed by name. config/root is not included.`
+
+const pathConfigConnectionsHelpSyn = `
+Configure a named Fauna connection that roles can create keys with.
+`
+
+const pathConfigConnectionsHelpDesc = `
+A mount can manage keys in more than one Fauna account, region group or
+endpoint. Each connection has its own secret, endpoint and rotation
+settings, with the same fields and behaviour as config/root. Roles and
+static roles pick a connection with their "connection" field; those without
+one use config/root.
+
+The root key of a connection can be rotated with "config/rotate-root/<name>".
+`
+
+const pathConfigRotateConnectionHelpSyn = `
+Request to rotate the Fauna root key of a named connection
+`
+
+const pathConfigRotateConnectionHelpDesc = `
+This path rotates the root key of a connection configured under
+config/connections, in the same way config/rotate-root does for config/root.
+`
+
+func pathListConfigConnections(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/connections/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathConnectionList,
+		},
+
+		HelpSynopsis:    pathListConfigConnectionsHelpSyn,
+		HelpDescription: pathListConfigConnectionsHelpDesc,
+	}
+}
+
+func pathConfigConnections(b *backend) *framework.Path {
+	fields := connectionConfigFields()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the connection",
+	}
+
+	return &framework.Path{
+		Pattern: "config/connections/" + framework.GenericNameRegex("name"),
+		Fields:  fields,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConnectionRead,
+			logical.UpdateOperation: b.pathConnectionWrite,
+			logical.DeleteOperation: b.pathConnectionDelete,
+		},
+
+		HelpSynopsis:    pathConfigConnectionsHelpSyn,
+		HelpDescription: pathConfigConnectionsHelpDesc,
+	}
+}
+
+func pathConfigRotateConnection(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/rotate-root/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the connection",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathConfigRotateConnectionUpdate,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+
+		HelpSynopsis:    pathConfigRotateConnectionHelpSyn,
+		HelpDescription: pathConfigRotateConnectionHelpDesc,
+	}
+}
+
+func (b *backend) pathConnectionList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.clientMutex.RLock()
+	defer b.clientMutex.RUnlock()
+	entries, err := req.Storage.List(ctx, connectionConfigPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathConnectionRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.readConnection(ctx, req.Storage, d.Get("name").(string))
+}
+
+func (b *backend) pathConnectionWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing connection name"), nil
+	}
+	return b.writeConnection(ctx, req.Storage, name, d)
+}
+
+func (b *backend) pathConnectionDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.deleteConnection(ctx, req.Storage, d.Get("name").(string))
+}
+
+func (b *backend) pathConfigRotateConnectionUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	b.clientMutex.RLock()
+	config, err := readRootConfig(ctx, req.Storage, name)
+	b.clientMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Connection '%s' not found", name)), nil
+	}
+
+	if err := b.rotateRoot(ctx, req.Storage, name); err != nil {
+		if err == errEmptyRootSecret {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+// checkConnection returns an error response if a role names a connection
+// that has not been configured. The empty name is config/root.
+func (b *backend) checkConnection(ctx context.Context, s logical.Storage, connection string) (*logical.Response, error) {
+	if connection == "" {
+		return nil, nil
+	}
+
+	b.clientMutex.RLock()
+	defer b.clientMutex.RUnlock()
+
+	config, err := readRootConfig(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse(fmt.Sprintf("Connection '%s' not found", connection)), nil
+	}
+	return nil, nil
+}
+
+// isAccountConnection reports whether connection holds a Fauna account key.
+func (b *backend) isAccountConnection(ctx context.Context, s logical.Storage, connection string) (bool, error) {
+	b.clientMutex.RLock()
+	defer b.clientMutex.RUnlock()
+
+	config, err := readRootConfig(ctx, s, connection)
+	if err != nil {
+		return false, err
+	}
+	return config != nil && config.Type == connectionTypeAccount, nil
+}
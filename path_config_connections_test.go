@@ -0,0 +1,140 @@
+package fauna
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_Connections(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, root := testBackendWithFauna(t, s)
+
+	other := newFakeFauna("other-secret")
+	defer other.Close()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "roles/missing",
+		Data:      map[string]any{"role": "server", "connection": "other"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an unknown connection: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/connections/other",
+		Data:      map[string]any{"secret": "other-secret", "endpoint": other.URL},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: connection writing failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Storage:   s,
+		Path:      "config/connections/",
+	})
+	if err != nil || resp == nil || len(resp.Data["keys"].([]string)) != 1 {
+		t.Fatalf("bad: expected one connection: resp:%#v\n err: %v", resp, err)
+	}
+
+	testWriteRole(t, b, s, "deploy", map[string]any{"role": "server"})
+	testWriteRole(t, b, s, "other", map[string]any{"role": "server", "connection": "other"})
+
+	if resp, err := testReadKey(b, s, "deploy", nil); err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	resp, err = testReadKey(b, s, "other", nil)
+	if err != nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if n := root.count("", "keys"); n != 1 {
+		t.Fatalf("expected 1 key on config/root, got %d", n)
+	}
+	if n := other.count("", "keys"); n != 1 {
+		t.Fatalf("expected 1 key on the named connection, got %d", n)
+	}
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if n := other.count("", "keys"); n != 0 {
+		t.Fatalf("expected the key to be deleted from the named connection, got %d keys", n)
+	}
+	if n := root.count("", "keys"); n != 1 {
+		t.Fatalf("expected the config/root key to be kept, got %d keys", n)
+	}
+
+	// Rotating the named connection leaves config/root alone
+	otherKey := other.addKey("", map[string]any{"role": "admin"})
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/connections/other",
+		Data:      map[string]any{"secret": otherKey, "endpoint": other.URL},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: connection writing failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root/other",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: connection rotation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if other.keyExists(otherKey) {
+		t.Fatal("expected the old connection key to be deleted")
+	}
+
+	config, err := readRootConfig(ctx, s, "other")
+	if err != nil || config == nil {
+		t.Fatalf("connection config not found: %v", err)
+	}
+	if !other.keyExists(config.Secret) {
+		t.Fatal("expected the connection to use a new key")
+	}
+	if config, err := readRootConfig(ctx, s, ""); err != nil || config.Secret != "root-secret" {
+		t.Fatalf("expected config/root to be unchanged: %#v, %v", config, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root/missing",
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error rotating an unknown connection: resp:%#v\n err: %v", resp, err)
+	}
+
+	// The connection can't go while a role still uses it
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Storage:   s,
+		Path:      "config/connections/other",
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error deleting a connection in use: resp:%#v\n err: %v", resp, err)
+	}
+	if config, err := readRootConfig(ctx, s, "other"); err != nil || config == nil {
+		t.Fatalf("expected the connection to be kept: %v", err)
+	}
+
+	b.invalidate(ctx, connectionConfigPrefix+"other")
+	if _, ok := b.faunaClients["other"]; ok {
+		t.Fatal("expected invalidate to clear the connection's client")
+	}
+}
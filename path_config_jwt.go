@@ -0,0 +1,234 @@
+package fauna
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	jwtConfigPath = "config/jwt"
+
+	defaultJWTRotationPeriod  = 24 * time.Hour
+	defaultJWTVerificationTTL = 24 * time.Hour
+)
+
+const pathConfigJWTHelpSyn = `
+Configure Vault as a JWT issuer for Fauna access providers.
+`
+
+const pathConfigJWTHelpDesc = `
+This configures the issuer of the JWTs returned by roles of type "jwt".
+"issuer" is the external URL of this mount, e.g.
+"https://vault.example.com/v1/fauna". Fauna fetches the signing keys from
+"<issuer>/jwks", which this mount serves without authentication.
+
+The signing key is rotated every "rotation_period". A replaced key stays
+published for "verification_ttl" so that tokens it signed can still be
+verified, which also caps the TTL of issued tokens.
+`
+
+const pathConfigRotateJWTHelpSyn = `
+Rotate the JWT signing key.
+`
+
+const pathConfigRotateJWTHelpDesc = `
+This makes a new signing key current. The replaced key stays published
+for the configured verification_ttl.
+`
+
+func pathConfigJWT(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: jwtConfigPath,
+		Fields: map[string]*framework.FieldSchema{
+			"issuer": {
+				Type:        framework.TypeString,
+				Description: "External URL of this mount, used as the 'iss' claim and to derive the JWKS URL.",
+			},
+			"rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the signing key is rotated. Defaults to 24h.",
+			},
+			"verification_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long a replaced signing key stays published. Caps the TTL of issued tokens. Defaults to 24h.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigJWTRead,
+			logical.CreateOperation: b.pathConfigJWTWrite,
+			logical.UpdateOperation: b.pathConfigJWTWrite,
+			logical.DeleteOperation: b.pathConfigJWTDelete,
+		},
+
+		ExistenceCheck: b.pathConfigJWTExistenceCheck,
+
+		HelpSynopsis:    pathConfigJWTHelpSyn,
+		HelpDescription: pathConfigJWTHelpDesc,
+	}
+}
+
+func pathConfigRotateJWT(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: jwtConfigPath + "/rotate",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathConfigRotateJWTUpdate,
+		},
+
+		HelpSynopsis:    pathConfigRotateJWTHelpSyn,
+		HelpDescription: pathConfigRotateJWTHelpDesc,
+	}
+}
+
+type jwtConfig struct {
+	Issuer          string        `json:"issuer"`
+	RotationPeriod  time.Duration `json:"rotation_period"`
+	VerificationTTL time.Duration `json:"verification_ttl"`
+}
+
+// jwksURI returns the URL Fauna fetches the signing keys from.
+func (c *jwtConfig) jwksURI() string {
+	return c.Issuer + "/" + jwksPath
+}
+
+// NOTE: The caller is required to ensure that b.jwtMutex is at least read locked
+func readJWTConfig(ctx context.Context, s logical.Storage) (*jwtConfig, error) {
+	entry, err := s.Get(ctx, jwtConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var config jwtConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (b *backend) pathConfigJWTExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	b.jwtMutex.RLock()
+	defer b.jwtMutex.RUnlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return config != nil, nil
+}
+
+func (b *backend) pathConfigJWTRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.RLock()
+	defer b.jwtMutex.RUnlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"issuer":           config.Issuer,
+			"jwks_uri":         config.jwksURI(),
+			"rotation_period":  int64(config.RotationPeriod.Seconds()),
+			"verification_ttl": int64(config.VerificationTTL.Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigJWTWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.Lock()
+	defer b.jwtMutex.Unlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &jwtConfig{
+			RotationPeriod:  defaultJWTRotationPeriod,
+			VerificationTTL: defaultJWTVerificationTTL,
+		}
+	}
+
+	if issuerRaw, ok := data.GetOk("issuer"); ok {
+		config.Issuer = strings.TrimSuffix(issuerRaw.(string), "/")
+	}
+	if config.Issuer == "" {
+		return logical.ErrorResponse("missing issuer"), nil
+	}
+	if u, err := url.Parse(config.Issuer); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return logical.ErrorResponse("issuer must be an http or https URL"), nil
+	}
+
+	if rotationPeriodRaw, ok := data.GetOk("rotation_period"); ok {
+		config.RotationPeriod = time.Duration(rotationPeriodRaw.(int)) * time.Second
+	}
+	if config.RotationPeriod < time.Minute {
+		return logical.ErrorResponse("rotation_period must be at least one minute"), nil
+	}
+
+	if verificationTTLRaw, ok := data.GetOk("verification_ttl"); ok {
+		config.VerificationTTL = time.Duration(verificationTTLRaw.(int)) * time.Second
+	}
+	if config.VerificationTTL <= 0 {
+		return logical.ErrorResponse("verification_ttl must be positive"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(jwtConfigPath, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	// Make sure there is a key to sign with
+	keys, err := readJWTKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if keys.current() == nil {
+		if err := rotateJWTKey(ctx, req.Storage, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigJWTDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.Lock()
+	defer b.jwtMutex.Unlock()
+
+	if err := req.Storage.Delete(ctx, jwtConfigPath); err != nil {
+		return nil, err
+	}
+	return nil, req.Storage.Delete(ctx, jwtKeysPath)
+}
+
+func (b *backend) pathConfigRotateJWTUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.Lock()
+	defer b.jwtMutex.Unlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse("the JWT issuer is not configured"), nil
+	}
+
+	return nil, rotateJWTKey(ctx, req.Storage, config)
+}
@@ -2,9 +2,14 @@ package fauna
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -24,8 +29,153 @@ backend is mounted at "fauna" and you create a role at "fauna/roles/deploy"
 then a user could request access credentials at "fauna/deploy".
 
 To validate the keys, attempt to read an access key after writing the policy.
+
+Roles of type "database" create a new child database under "database" for
+every key, named by "database_name_template", and return a key scoped to it.
+Revoking the lease deletes both. "schema_statements" are run in order inside
+the new database before the key is returned, to create its collections,
+indexes, functions and roles. Each statement is a query in Fauna's JSON wire
+format, e.g. {"create_collection": {"object": {"name": "users"}}}.
+
+Every key's data holds the role's "extra" data, and the name rendered from
+"name_template" under "name", if set. Both are rendered for every request
+like Vault's username templates, with .RoleName, .DisplayName, functions such
+as "random" and "lowercase", and the identity directives of ACL templates,
+such as {{identity.entity.metadata.team}}. Identity directives need the
+request to come from an entity. Fauna ABAC predicates that read the key's
+data can so depend on who asked for it.
+
+Requesters may pick the database of a key instead of "database", as long as
+it matches an entry of "allowed_databases". Entries take globs such as
+"tenants/*" and identity directives such as
+"tenant-{{identity.entity.metadata.tenant}}", which only match for callers
+with that metadata. For roles of type "database" the picked database is the
+parent of the created one.
+
+Requesters may add their own string values to the data of a key with the
+"metadata" parameter, for the data keys matching "allowed_metadata_keys" and
+none of "denied_metadata_keys". Both take globs such as "build_*". They can't
+replace what the role sets.
+
+Roles of type "token" issue Fauna tokens for a document in "collection",
+named by "document_id" or found through "index" and "index_terms", in
+"database". Tokens carry the identity of the document, so ABAC roles can
+check it. Revoking the lease deletes the token.
+
+With "entity_index" set, a token role issues tokens for the calling Vault
+entity instead. Its document in "collection" is found through the index,
+which must have the term data.vault_entity_id, and is created or updated
+with the entity's name, metadata and aliases on every request.
+
+Instead of naming an existing Fauna role in "role", a role of type "key" can
+define one with "privileges" and optionally "membership", both in Fauna's
+JSON wire format. Every key then gets a new Fauna role with that definition,
+named like the databases of roles of type "database", and revoking the lease
+deletes both.
+
+Roles of type "scoped" create one admin key per lease and return secrets
+scoped from it for every entry of "scopes", each a nested database path under
+"database" and a role separated by ":", e.g. "team/app:server". That reaches
+many databases with a single Fauna write, and revoking the lease deletes the
+key, which invalidates all of them. A scoped secret is the admin key's secret
+with the scope appended, so anyone holding one can remove the scope and act
+as admin of the database the key is for. That database is the narrowest one
+under "database" that is a parent of every scope, so keep the scopes of a
+role close together. A role whose scopes have no common parent below the
+connection's database must set "database".
+
+With "key_ttl_margin" set, keys issued over FQL v4 also get a ttl in Fauna,
+the end of their lease plus the margin, which every renewal moves forward.
+Fauna then deletes a key by itself if Vault fails to revoke it. For roles of
+type "database" only the key expires; the database stays until the lease is
+revoked. Likewise the Fauna role made for every key of a role with
+"privileges" outlives its key. Roles of type "token" ignore the margin.
+
+Roles of type "jwt" issue short-lived JWTs signed by this mount, for a Fauna
+access provider in "database" named "access_provider". The JWTs are not
+leased. Write "access-providers/<role>" to create or update the access
+provider, which also records its "audience" on the role.
 `
 
+const (
+	roleTypeKey      = "key"
+	roleTypeDatabase = "database"
+	roleTypeToken    = "token"
+	roleTypeJWT      = "jwt"
+	roleTypeScoped   = "scoped"
+)
+
+const defaultDatabaseNameTemplate = `{{ printf "vault-%s-%s-%s" (.RoleName | truncate 24) (.DisplayName | truncate 24) (random 12) | lowercase }}`
+
+// validDatabasePath reports whether path is empty or a slash separated path
+// of database names, such as "org/team/app".
+func validDatabasePath(path string) bool {
+	if path == "" {
+		return true
+	}
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// splitScope splits an entry of a role's scopes into its database path and
+// role.
+func splitScope(scope string) (string, string, bool) {
+	i := strings.LastIndex(scope, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.Trim(scope[:i], "/"), scope[i+1:], true
+}
+
+// scopeRoot returns the narrowest database, relative to the role's database,
+// that is a parent of every entry of a role's scopes. The key of a role of
+// type "scoped" is an admin key for it, as anyone holding a scoped secret can
+// strip its suffix and act with that key.
+func (r *FaunaRoleEntry) scopeRoot() string {
+	var root []string
+	for i, scope := range r.Scopes {
+		database, _, _ := splitScope(scope)
+		parent, _ := splitDatabasePath(database)
+		var names []string
+		if parent != "" {
+			names = strings.Split(parent, "/")
+		}
+		if i == 0 {
+			root = names
+			continue
+		}
+		n := 0
+		for n < len(root) && n < len(names) && root[n] == names[n] {
+			n++
+		}
+		root = root[:n]
+	}
+	return strings.Join(root, "/")
+}
+
+// checkAccountKey returns an error response if the role asks for what keys
+// created through the Account API can't have. Those only have a built-in
+// role and carry no data.
+func (r *FaunaRoleEntry) checkAccountKey() *logical.Response {
+	switch {
+	case strings.HasPrefix(r.Role, "roles/"):
+		return logical.ErrorResponse(fmt.Sprintf(
+			"keys issued over an %s connection need a built-in 'role'", connectionTypeAccount))
+	case len(r.Extra) > 0 || r.NameTemplate != "":
+		return logical.ErrorResponse(fmt.Sprintf(
+			"keys issued over an %s connection can't carry 'extra' or 'name_template'", connectionTypeAccount))
+	}
+	return nil
+}
+
+// invalidDatabaseNameChars matches what may not appear in a database name
+// rendered from a template.
+var invalidDatabaseNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
 func pathListRoles(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?$",
@@ -55,12 +205,123 @@ func pathRoles(b *backend) *framework.Path {
 
 			"database": {
 				Type:        framework.TypeString,
-				Description: `A reference for the database associated with this key.`,
+				Description: `Path of the database associated with this key, e.g. "org/team/app" for a nested database. For roles of type "database", the parent of the created databases.`,
+			},
+
+			"allowed_databases": {
+				Type:        framework.TypeStringSlice,
+				Description: `Databases requesters may pick instead of "database" with the "database" parameter. Supports globs such as "tenants/*" and identity directives such as "tenant-{{identity.entity.metadata.tenant}}". Empty allows none.`,
 			},
 
 			"extra": {
 				Type:        framework.TypeMap,
-				Description: `map of data to add to the generated key`,
+				Description: `map of data to add to the generated key. String values may be templates, rendered like "name_template".`,
+			},
+
+			"allowed_metadata_keys": {
+				Type:        framework.TypeStringSlice,
+				Description: `Keys of the data requesters may add to keys with "metadata". Supports globs such as "build_*". Empty allows none.`,
+			},
+
+			"denied_metadata_keys": {
+				Type:        framework.TypeStringSlice,
+				Description: `Keys of the data requesters may never add, even if "allowed_metadata_keys" matches them. Supports globs.`,
+			},
+
+			"name_template": {
+				Type:        framework.TypeString,
+				Description: `Template for the name recorded in the data of every key, e.g. "{{.RoleName}}-{{.DisplayName}}-{{random 8}}". Has .RoleName, .DisplayName and identity directives such as "{{identity.entity.metadata.team}}".`,
+			},
+
+			"type": {
+				Type:        framework.TypeString,
+				Description: `"key" to issue keys for an existing database, "database" to create a new child database for every key, "token" to issue tokens for a document, "jwt" to issue JWTs for an access provider, or "scoped" to return scoped secrets of a single key.`,
+				Default:     roleTypeKey,
+			},
+
+			"collection": {
+				Type:        framework.TypeString,
+				Description: `Collection of the document tokens are issued for. Used by roles of type "token" with "document_id".`,
+			},
+
+			"document_id": {
+				Type:        framework.TypeString,
+				Description: `ID of the document tokens are issued for. Used by roles of type "token".`,
+			},
+
+			"index": {
+				Type:        framework.TypeString,
+				Description: `Index to look up the document tokens are issued for, instead of "document_id". The first match is used.`,
+			},
+
+			"index_terms": {
+				Type:        framework.TypeStringSlice,
+				Description: `Terms to match in "index".`,
+			},
+
+			"entity_index": {
+				Type:        framework.TypeString,
+				Description: `Index on data.vault_entity_id in "collection". When set, tokens are issued for a document representing the calling Vault entity.`,
+			},
+
+			"access_provider": {
+				Type:        framework.TypeString,
+				Description: `Name of the Fauna access provider JWTs are issued for. Used by roles of type "jwt". Defaults to "vault-<role name>".`,
+			},
+
+			"provider_roles": {
+				Type:        framework.TypeStringSlice,
+				Description: `Fauna roles the access provider grants to JWTs of roles of type "jwt".`,
+			},
+
+			"audience": {
+				Type:        framework.TypeString,
+				Description: `"aud" claim of the JWTs, the audience of the access provider. Set by writing access-providers/<role>.`,
+			},
+
+			"scopes": {
+				Type:        framework.TypeStringSlice,
+				Description: `Scoped secrets returned by roles of type "scoped", each "<database path>:<role>" relative to "database", e.g. "team/app:roles/reader".`,
+			},
+
+			"privileges": {
+				Type:        framework.TypeString,
+				Description: `Privileges of a Fauna role created for every key, as a JSON array in Fauna's wire format. Replaces "role".`,
+			},
+
+			"membership": {
+				Type:        framework.TypeString,
+				Description: `Membership of the Fauna role created for every key, in Fauna's JSON wire format. Requires "privileges".`,
+			},
+
+			"database_name_template": {
+				Type:        framework.TypeString,
+				Description: `Template for the names of databases created by roles of type "database". Has .RoleName and .DisplayName.`,
+			},
+
+			"schema_statements": {
+				Type:        framework.TypeStringSlice,
+				Description: `Queries in Fauna's JSON wire format to run in order in databases created by roles of type "database".`,
+			},
+
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Default lease for keys generated by this role. Falls back to config/lease.`,
+			},
+
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Maximum lease for keys generated by this role. Falls back to config/lease.`,
+			},
+
+			"connection": {
+				Type:        framework.TypeString,
+				Description: `Name of the connection under config/connections to create keys with. Defaults to config/root.`,
+			},
+
+			"key_ttl_margin": {
+				Type:        framework.TypeDurationSecond,
+				Description: `When set, keys get a ttl in Fauna this long after their lease ends, moved on renewal, so Fauna deletes them even if revocation fails. 0 disables it.`,
 			},
 		},
 
@@ -132,12 +393,224 @@ func (b *backend) pathRolesWrite(ctx context.Context, req *logical.Request, d *f
 	}
 
 	if databaseRaw, ok := d.GetOk("database"); ok {
-		roleEntry.Database = databaseRaw.(string)
+		roleEntry.Database = strings.Trim(databaseRaw.(string), "/")
+	}
+	if !validDatabasePath(roleEntry.Database) {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"invalid database path %q", roleEntry.Database)), nil
+	}
+
+	if allowedRaw, ok := d.GetOk("allowed_databases"); ok {
+		roleEntry.AllowedDatabases = allowedRaw.([]string)
+	}
+	for _, allowed := range roleEntry.AllowedDatabases {
+		if err := validateTemplate(allowed); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid template %q in 'allowed_databases': %s", allowed, err)), nil
+		}
 	}
 
 	if extraRaw, ok := d.GetOk("extra"); ok {
 		roleEntry.Extra = extraRaw.(map[string]any)
 	}
+	for _, extraTemplate := range extraTemplates(roleEntry.Extra) {
+		if err := validateTemplate(extraTemplate); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid template %q in 'extra': %s", extraTemplate, err)), nil
+		}
+	}
+
+	if allowedRaw, ok := d.GetOk("allowed_metadata_keys"); ok {
+		roleEntry.AllowedMetadataKeys = allowedRaw.([]string)
+	}
+	if deniedRaw, ok := d.GetOk("denied_metadata_keys"); ok {
+		roleEntry.DeniedMetadataKeys = deniedRaw.([]string)
+	}
+
+	if nameTemplateRaw, ok := d.GetOk("name_template"); ok {
+		roleEntry.NameTemplate = nameTemplateRaw.(string)
+	}
+	if roleEntry.NameTemplate != "" {
+		if err := validateTemplate(roleEntry.NameTemplate); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid 'name_template': %s", err)), nil
+		}
+	}
+
+	if typeRaw, ok := d.GetOk("type"); ok {
+		roleEntry.Type = typeRaw.(string)
+	} else if roleEntry.Type == "" {
+		roleEntry.Type = d.Get("type").(string)
+	}
+	switch roleEntry.Type {
+	case roleTypeKey, roleTypeDatabase, roleTypeToken, roleTypeJWT, roleTypeScoped:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'type' must be %q, %q, %q, %q or %q", roleTypeKey, roleTypeDatabase, roleTypeToken, roleTypeJWT, roleTypeScoped)), nil
+	}
+	if len(roleEntry.AllowedDatabases) > 0 && roleEntry.Type == roleTypeJWT {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'allowed_databases' can't be used with 'type' %q", roleTypeJWT)), nil
+	}
+
+	if collectionRaw, ok := d.GetOk("collection"); ok {
+		roleEntry.Collection = collectionRaw.(string)
+	}
+	if documentIDRaw, ok := d.GetOk("document_id"); ok {
+		roleEntry.DocumentID = documentIDRaw.(string)
+	}
+	if indexRaw, ok := d.GetOk("index"); ok {
+		roleEntry.Index = indexRaw.(string)
+	}
+	if termsRaw, ok := d.GetOk("index_terms"); ok {
+		roleEntry.IndexTerms = termsRaw.([]string)
+	}
+	if entityIndexRaw, ok := d.GetOk("entity_index"); ok {
+		roleEntry.EntityIndex = entityIndexRaw.(string)
+	}
+	if roleEntry.Type == roleTypeToken && roleEntry.EntityIndex != "" {
+		switch {
+		case roleEntry.DocumentID != "" || roleEntry.Index != "":
+			return logical.ErrorResponse("'entity_index' cannot be combined with 'document_id' or 'index'"), nil
+		case roleEntry.Collection == "":
+			return logical.ErrorResponse("'collection' is required with 'entity_index'"), nil
+		}
+	} else if roleEntry.Type == roleTypeToken {
+		switch {
+		case roleEntry.DocumentID != "" && roleEntry.Index != "":
+			return logical.ErrorResponse("only one of 'document_id' and 'index' can be set"), nil
+		case roleEntry.DocumentID != "" && roleEntry.Collection == "":
+			return logical.ErrorResponse("'collection' is required with 'document_id'"), nil
+		case roleEntry.DocumentID == "" && roleEntry.Index == "":
+			return logical.ErrorResponse("one of 'document_id', 'index' and 'entity_index' is required for roles of type \"token\""), nil
+		}
+	}
+
+	if accessProviderRaw, ok := d.GetOk("access_provider"); ok {
+		roleEntry.AccessProvider = accessProviderRaw.(string)
+	}
+	if providerRolesRaw, ok := d.GetOk("provider_roles"); ok {
+		roleEntry.ProviderRoles = providerRolesRaw.([]string)
+	}
+	if audienceRaw, ok := d.GetOk("audience"); ok {
+		roleEntry.Audience = audienceRaw.(string)
+	}
+
+	if scopesRaw, ok := d.GetOk("scopes"); ok {
+		roleEntry.Scopes = scopesRaw.([]string)
+	}
+	if roleEntry.Type == roleTypeScoped {
+		switch {
+		case roleEntry.Role != "" && roleEntry.Role != "admin":
+			return logical.ErrorResponse(fmt.Sprintf(
+				"roles of type %q create admin keys, 'role' must be empty or \"admin\"", roleTypeScoped)), nil
+		case len(roleEntry.Scopes) == 0:
+			return logical.ErrorResponse(fmt.Sprintf(
+				"'scopes' is required for roles of type %q", roleTypeScoped)), nil
+		}
+		for _, scope := range roleEntry.Scopes {
+			database, role, ok := splitScope(scope)
+			if !ok || database == "" || role == "" || !validDatabasePath(database) {
+				return logical.ErrorResponse(fmt.Sprintf(
+					"invalid scope %q, expected \"<database path>:<role>\"", scope)), nil
+			}
+		}
+		// Scoped secrets give away the admin key, which must not be one for
+		// the connection's own database
+		if joinDatabasePath(roleEntry.Database, roleEntry.scopeRoot()) == "" {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"roles of type %q must set 'database' or have scopes in a common child database, their key would be an admin key for the connection's database",
+				roleTypeScoped)), nil
+		}
+	} else if len(roleEntry.Scopes) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'scopes' requires 'type' to be %q", roleTypeScoped)), nil
+	}
+
+	if privilegesRaw, ok := d.GetOk("privileges"); ok {
+		roleEntry.Privileges = privilegesRaw.(string)
+	}
+	if membershipRaw, ok := d.GetOk("membership"); ok {
+		roleEntry.Membership = membershipRaw.(string)
+	}
+	if roleEntry.Privileges != "" {
+		var privileges []json.RawMessage
+		switch {
+		case roleEntry.Type != roleTypeKey:
+			return logical.ErrorResponse(fmt.Sprintf(
+				"'privileges' requires 'type' to be %q", roleTypeKey)), nil
+		case roleEntry.Role != "":
+			return logical.ErrorResponse("only one of 'role' and 'privileges' can be set"), nil
+		case json.Unmarshal([]byte(roleEntry.Privileges), &privileges) != nil:
+			return logical.ErrorResponse("'privileges' must be a JSON array"), nil
+		case roleEntry.Membership != "" && !json.Valid([]byte(roleEntry.Membership)):
+			return logical.ErrorResponse("'membership' is not valid JSON"), nil
+		}
+	} else if roleEntry.Membership != "" {
+		return logical.ErrorResponse("'membership' requires 'privileges'"), nil
+	}
+
+	if templateRaw, ok := d.GetOk("database_name_template"); ok {
+		roleEntry.DatabaseNameTemplate = templateRaw.(string)
+	}
+	if roleEntry.Type == roleTypeDatabase {
+		if _, err := roleEntry.databaseName(roleName, "validation"); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid 'database_name_template': %s", err)), nil
+		}
+	}
+
+	if statementsRaw, ok := d.GetOk("schema_statements"); ok {
+		roleEntry.SchemaStatements = statementsRaw.([]string)
+	}
+	if len(roleEntry.SchemaStatements) > 0 && roleEntry.Type != roleTypeDatabase {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'schema_statements' requires 'type' to be %q", roleTypeDatabase)), nil
+	}
+	for i, statement := range roleEntry.SchemaStatements {
+		if !json.Valid([]byte(statement)) {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"'schema_statements' entry %d is not valid JSON", i)), nil
+		}
+	}
+
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		roleEntry.TTL = time.Duration(ttlRaw.(int)) * time.Second
+	}
+
+	if maxTTLRaw, ok := d.GetOk("max_ttl"); ok {
+		roleEntry.MaxTTL = time.Duration(maxTTLRaw.(int)) * time.Second
+	}
+
+	if connectionRaw, ok := d.GetOk("connection"); ok {
+		roleEntry.Connection = connectionRaw.(string)
+	}
+
+	if marginRaw, ok := d.GetOk("key_ttl_margin"); ok {
+		roleEntry.KeyTTLMargin = time.Duration(marginRaw.(int)) * time.Second
+	}
+	if roleEntry.KeyTTLMargin < 0 {
+		return logical.ErrorResponse("'key_ttl_margin' must not be negative"), nil
+	}
+	if errResp, err := b.checkConnection(ctx, req.Storage, roleEntry.Connection); errResp != nil || err != nil {
+		return errResp, err
+	}
+	account, err := b.isAccountConnection(ctx, req.Storage, roleEntry.Connection)
+	if err != nil {
+		return nil, err
+	}
+	if account {
+		if errResp := roleEntry.checkAccountKey(); errResp != nil {
+			return errResp, nil
+		}
+	}
+
+	if roleEntry.TTL < 0 || roleEntry.MaxTTL < 0 {
+		return logical.ErrorResponse("ttl and max_ttl must not be negative"), nil
+	}
+	if roleEntry.MaxTTL > 0 && roleEntry.TTL > roleEntry.MaxTTL {
+		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
+	}
 
 	err = setFaunaRole(ctx, req.Storage, roleName, roleEntry)
 	if err != nil {
@@ -213,16 +686,123 @@ func setFaunaRole(ctx context.Context, s logical.Storage, roleName string, roleE
 }
 
 type FaunaRoleEntry struct {
-	Role     string         `json:"role"`     // Fauna role to associated with the key.
-	Database string         `json:"database"` // Fauna database to associated with the key.
-	Extra    map[string]any `json:"extra"`    // JSON-serialized inline extra data to add to the key.
+	Role       string         `json:"role"`       // Fauna role to associated with the key.
+	Database   string         `json:"database"`   // Path of the Fauna database associated with the key, e.g. "org/team/app".
+	Extra      map[string]any `json:"extra"`      // JSON-serialized inline extra data to add to the key. String values may be templates.
+	TTL        time.Duration  `json:"ttl"`        // Default lease for keys, overrides config/lease.
+	MaxTTL     time.Duration  `json:"max_ttl"`    // Maximum lease for keys, overrides config/lease.
+	Connection string         `json:"connection"` // Named connection to create keys with, "" for config/root.
+	Type       string         `json:"type"`       // "key", or "database" to create a database per key. "" is "key".
+
+	KeyTTLMargin time.Duration `json:"key_ttl_margin"` // How long after its lease ends a key expires in Fauna, 0 for never.
+	NameTemplate string        `json:"name_template"`  // Template for the name in the data of every key, "" for none.
+
+	AllowedMetadataKeys []string `json:"allowed_metadata_keys"` // Globs of the data keys requesters may set.
+	DeniedMetadataKeys  []string `json:"denied_metadata_keys"`  // Globs of the data keys requesters may never set.
+
+	AllowedDatabases []string `json:"allowed_databases"` // Globs of the databases requesters may pick instead of Database.
+
+	DatabaseNameTemplate string   `json:"database_name_template"` // Template for created database names, "" for the default.
+	SchemaStatements     []string `json:"schema_statements"`      // Wire format queries run in created databases.
+
+	Collection string   `json:"collection"`  // Collection of the document tokens are issued for.
+	DocumentID string   `json:"document_id"` // ID of the document tokens are issued for.
+	Index      string   `json:"index"`       // Index to find the document tokens are issued for.
+	IndexTerms []string `json:"index_terms"` // Terms to match in Index.
+
+	EntityIndex string `json:"entity_index"` // Index on data.vault_entity_id to find the caller's document.
+
+	AccessProvider string   `json:"access_provider"` // Access provider JWTs are issued for, "" for "vault-<role name>".
+	ProviderRoles  []string `json:"provider_roles"`  // Fauna roles the access provider grants.
+	Audience       string   `json:"audience"`        // "aud" claim of issued JWTs.
+
+	Scopes []string `json:"scopes"` // "<database path>:<role>" of the secrets scoped from a key.
+
+	Privileges string `json:"privileges"` // Wire format privileges of a Fauna role created per key.
+	Membership string `json:"membership"` // Wire format membership of that role.
+}
+
+// accessProviderName returns the name of the access provider a role of type
+// "jwt" issues JWTs for.
+func (r *FaunaRoleEntry) accessProviderName(roleName string) string {
+	if r.AccessProvider != "" {
+		return r.AccessProvider
+	}
+	return "vault-" + roleName
+}
+
+// metadataKeyAllowed reports whether requesters may set key in the data of
+// the role's keys. Denied keys win over allowed ones.
+func (r *FaunaRoleEntry) metadataKeyAllowed(key string) bool {
+	if strutil.StrListContainsGlob(r.DeniedMetadataKeys, key) {
+		return false
+	}
+	return strutil.StrListContainsGlob(r.AllowedMetadataKeys, key)
+}
+
+// databaseName renders the name of a new child database for a role of type
+// "database".
+func (r *FaunaRoleEntry) databaseName(roleName, displayName string) (string, error) {
+	rawTemplate := r.DatabaseNameTemplate
+	if rawTemplate == "" {
+		rawTemplate = defaultDatabaseNameTemplate
+	}
+	return renderName(rawTemplate, roleName, displayName)
+}
+
+// faunaRoleName renders the name of the Fauna role created for a key of a
+// role with privileges. It follows the default database name template.
+func (r *FaunaRoleEntry) faunaRoleName(roleName, displayName string) (string, error) {
+	return renderName(defaultDatabaseNameTemplate, roleName, displayName)
+}
+
+// renderName renders the name of a Fauna resource created for a key.
+// Characters Fauna does not allow in names are replaced by "-".
+func renderName(rawTemplate, roleName, displayName string) (string, error) {
+	name, err := renderTemplate(rawTemplate, templateInput{RoleName: roleName, DisplayName: displayName})
+	if err != nil {
+		return "", err
+	}
+
+	name = invalidDatabaseNameChars.ReplaceAllString(name, "-")
+	if name == "" {
+		return "", fmt.Errorf("template rendered an empty name")
+	}
+	return name, nil
 }
 
 func (r *FaunaRoleEntry) toResponseData() map[string]any {
+	roleType := r.Type
+	if roleType == "" {
+		roleType = roleTypeKey
+	}
+
 	respData := map[string]any{
-		"role":     r.Role,
-		"database": r.Database,
-		"extra":    r.Extra,
+		"role":                   r.Role,
+		"database":               r.Database,
+		"allowed_databases":      r.AllowedDatabases,
+		"extra":                  r.Extra,
+		"name_template":          r.NameTemplate,
+		"allowed_metadata_keys":  r.AllowedMetadataKeys,
+		"denied_metadata_keys":   r.DeniedMetadataKeys,
+		"ttl":                    int64(r.TTL.Seconds()),
+		"max_ttl":                int64(r.MaxTTL.Seconds()),
+		"connection":             r.Connection,
+		"key_ttl_margin":         int64(r.KeyTTLMargin.Seconds()),
+		"type":                   roleType,
+		"database_name_template": r.DatabaseNameTemplate,
+		"schema_statements":      r.SchemaStatements,
+		"collection":             r.Collection,
+		"document_id":            r.DocumentID,
+		"index":                  r.Index,
+		"index_terms":            r.IndexTerms,
+		"entity_index":           r.EntityIndex,
+		"access_provider":        r.AccessProvider,
+		"provider_roles":         r.ProviderRoles,
+		"audience":               r.Audience,
+		"scopes":                 r.Scopes,
+		"privileges":             r.Privileges,
+		"membership":             r.Membership,
 	}
 
 	return respData
@@ -2,7 +2,14 @@ package fauna
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -14,27 +21,39 @@ Configure the root credentials that are used to manage Fauna.
 const pathConfigRootHelpDesc = `
 Before doing anything, the Fauna backend needs credentials that are able
 to manage Fauna keys. This endpoint is used to configure those credentials.
+
+When "rotation_period" is set, Vault rotates the root key automatically once
+the period has passed since the last rotation. "old_key_grace_period" keeps
+the replaced key valid for a while, so that other nodes still using it
+keep working until they pick up the new one.
+
+Unless "verify_connection" is false, the secret and endpoint are checked
+against Fauna before they are saved.
+
+"api_version" selects the Fauna API keys are managed with: "4" for FQL v4,
+the default, or "10" for FQL v10. Connections using "10" only issue keys
+from roles of type "key" and can't be rotated by Vault.
+
+"type" is "database" for a connection holding a database secret, the
+default, or "account" for one holding a Fauna account key. Account
+connections manage top-level databases through the Fauna Account API, in the
+region group set by "region_group". They only issue secrets from roles of
+type "database" without a parent database, with keys of a built-in role that
+carry no data, and can't be rotated by Vault.
+The type of a connection can't be changed once it is written.
+
+A connection can't be deleted while roles or static roles still use it.
 `
 
 func pathConfigRoot(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config/root",
-		Fields: map[string]*framework.FieldSchema{
-			"secret": {
-				Type:        framework.TypeString,
-				Description: "Fauna secret with permission to create new keys.",
-				Required:    true,
-			},
-			"endpoint": {
-				Type:        framework.TypeString,
-				Description: "Endpoint to custom Fauna server URL",
-				Required:    true,
-			},
-		},
+		Fields:  connectionConfigFields(),
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation:   b.pathConfigRootRead,
 			logical.UpdateOperation: b.pathConfigRootWrite,
+			logical.DeleteOperation: b.pathConfigRootDelete,
 		},
 
 		HelpSynopsis:    pathConfigRootHelpSyn,
@@ -42,60 +61,409 @@ func pathConfigRoot(b *backend) *framework.Path {
 	}
 }
 
+// connectionConfigFields returns the fields shared by config/root and the
+// named connections under config/connections/.
+func connectionConfigFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"secret": {
+			Type:        framework.TypeString,
+			Description: "Fauna secret with permission to create new keys. Required when the connection is created, and kept when left out of later writes. This is write-only and never returned.",
+		},
+		"endpoint": {
+			Type:        framework.TypeString,
+			Description: "Endpoint to custom Fauna server URL. Kept when left out of later writes.",
+		},
+		"rotation_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How often Vault rotates the root key automatically. 0 disables automatic rotation.",
+		},
+		"verify_connection": {
+			Type:        framework.TypeBool,
+			Description: "Check that the secret and endpoint work and can manage keys before saving them.",
+			Default:     true,
+		},
+		"old_key_grace_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How long the previous root key stays valid after a rotation. 0 deletes it immediately.",
+		},
+		"api_version": {
+			Type:        framework.TypeString,
+			Description: `Fauna API to manage keys with, "4" for FQL v4 or "10" for FQL v10. Defaults to "4".`,
+		},
+		"type": {
+			Type:        framework.TypeString,
+			Description: `"database" if the secret is a database secret, or "account" if it is a Fauna account key. Defaults to "database".`,
+		},
+		"region_group": {
+			Type:        framework.TypeString,
+			Description: `Region group account connections create databases in. Defaults to "` + defaultRegionGroup + `".`,
+		},
+	}
+}
+
+// rootConfig holds the credentials of a connection to Fauna. config/root is
+// the default connection; named ones are stored under config/connections/.
 type rootConfig struct {
-	Secret   string `json:"secret"`
-	Endpoint string `json:"endpoint"`
+	Secret            string        `json:"secret"`
+	Endpoint          string        `json:"endpoint"`
+	RotationPeriod    time.Duration `json:"rotation_period"`
+	LastRotated       time.Time     `json:"last_rotated"`
+	OldKeyGracePeriod time.Duration `json:"old_key_grace_period"`
+	PreviousRef       string        `json:"previous_ref"`
+	PreviousSecret    string        `json:"previous_secret"` // set when the previous key lives outside the database it acts in
+	PreviousExpiresAt time.Time     `json:"previous_expires_at"`
+	APIVersion        string        `json:"api_version"` // "" for connections from before api_version, which use "4"
+	Type              string        `json:"type"`        // "" for connections from before type, which are "database"
+	RegionGroup       string        `json:"region_group"`
+}
+
+// NOTE: The caller is required to ensure that b.clientMutex is at least read locked
+func readRootConfig(ctx context.Context, s logical.Storage, connection string) (*rootConfig, error) {
+	entry, err := s.Get(ctx, connectionConfigPath(connection))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var config rootConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, errwrap.Wrapf("error reading root configuration: {{err}}", err)
+	}
+	return &config, nil
+}
+
+// NOTE: The caller is required to hold b.clientMutex for writing
+func writeRootConfig(ctx context.Context, s logical.Storage, connection string, config *rootConfig) error {
+	entry, err := logical.StorageEntryJSON(connectionConfigPath(connection), config)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// nextRotation returns when the root key is next due to be rotated, or the
+// zero time when automatic rotation is disabled.
+func (c *rootConfig) nextRotation() time.Time {
+	if c.RotationPeriod <= 0 || c.Secret == "" {
+		return time.Time{}
+	}
+	return c.LastRotated.Add(c.RotationPeriod)
+}
+
+// newClient builds a client for secret that talks to Fauna the way the
+// connection is configured to.
+func (c *rootConfig) newClient(secret string, logger hclog.Logger) (*FaunaClient, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" && c.Type == connectionTypeAccount {
+		endpoint = defaultAccountEndpoint
+	}
+
+	client, err := newFaunaClient(secret, endpoint, logger)
+	if err != nil {
+		return nil, err
+	}
+	client.apiVersion = c.APIVersion
+	client.connectionType = c.Type
+	client.regionGroup = c.RegionGroup
+	return client, nil
+}
+
+// secretFingerprint identifies a secret without revealing it.
+func secretFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
 
 func (b *backend) pathConfigRootRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.readConnection(ctx, req.Storage, "")
+}
+
+func (b *backend) pathConfigRootDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.deleteConnection(ctx, req.Storage, "")
+}
+
+func (b *backend) pathConfigRootWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.writeConnection(ctx, req.Storage, "", data)
+}
+
+func (b *backend) readConnection(ctx context.Context, s logical.Storage, connection string) (*logical.Response, error) {
+	// have to get the client first because that takes out a read lock
+	client, clientErr := b.client(ctx, s, connection)
+
 	b.clientMutex.RLock()
 	defer b.clientMutex.RUnlock()
 
-	entry, err := req.Storage.Get(ctx, "config/root")
+	config, err := readRootConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
-	if entry == nil {
+	if config == nil {
 		return nil, nil
 	}
 
-	var config rootConfig
+	apiVersion := config.APIVersion
+	if apiVersion == "" {
+		apiVersion = apiVersion4
+	}
+	connectionType := config.Type
+	if connectionType == "" {
+		connectionType = connectionTypeDatabase
+	}
 
-	if err := entry.DecodeJSON(&config); err != nil {
+	resp := &logical.Response{}
+	configData := map[string]any{
+		"endpoint":             config.Endpoint,
+		"type":                 connectionType,
+		"api_version":          apiVersion,
+		"rotation_period":      int64(config.RotationPeriod.Seconds()),
+		"old_key_grace_period": int64(config.OldKeyGracePeriod.Seconds()),
+	}
+	if !config.LastRotated.IsZero() {
+		configData["last_rotated"] = config.LastRotated.Format(time.RFC3339)
+	}
+	if next := config.nextRotation(); !next.IsZero() {
+		configData["next_rotation"] = next.Format(time.RFC3339)
+	}
+	if config.PreviousRef != "" {
+		configData["previous_key_expires_at"] = config.PreviousExpiresAt.Format(time.RFC3339)
+	}
+	if connectionType == connectionTypeAccount {
+		configData["region_group"] = config.RegionGroup
+	}
+
+	// The secret itself is never returned, only what identifies it and what
+	// Fauna knows about its key. Neither the v10 API nor the Account API can
+	// look keys up by secret.
+	if config.Secret != "" && apiVersion == apiVersion4 && connectionType == connectionTypeDatabase {
+		configData["secret_fingerprint"] = secretFingerprint(config.Secret)
+
+		var faunaKey *FaunaKey
+		err := clientErr
+		if err == nil {
+			faunaKey, err = client.keyFromSecret(config.Secret)
+		}
+
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("Unable to look up the root key in Fauna: %s", err))
+		} else {
+			ref, _ := faunaKey.Ref.MarshalJSON()
+			configData["hashed_secret"] = faunaKey.HashedSecret
+			configData["key_ref"] = string(ref)
+			configData["key_role"] = faunaKey.roleName()
+			configData["key_database"] = faunaKey.Database.ID
+			configData["key_created"] = time.UnixMicro(faunaKey.TS).UTC().Format(time.RFC3339)
+		}
+	}
+
+	resp.Data = configData
+	return resp, nil
+}
+
+func (b *backend) deleteConnection(ctx context.Context, s logical.Storage, connection string) (*logical.Response, error) {
+	// Roles and static roles name their connection, and so do the leases
+	// they issue, which couldn't be revoked anymore. Holding roleMutex keeps
+	// new roles from picking up the connection meanwhile.
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+
+	users, err := connectionUsers(ctx, s, connection)
+	if err != nil {
 		return nil, err
 	}
+	if len(users) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"%s is still used by %s", connectionConfigPath(connection), strings.Join(users, ", "))), nil
+	}
 
-	configData := map[string]any{
-		"secret":   config.Secret,
-		"endpoint": config.Endpoint,
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	if err := s.Delete(ctx, connectionConfigPath(connection)); err != nil {
+		return nil, err
 	}
-	return &logical.Response{
-		Data: configData,
-	}, nil
+
+	delete(b.faunaClients, connection)
+
+	return nil, nil
 }
 
-func (b *backend) pathConfigRootWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	endpoint := data.Get("endpoint").(string)
-	secret := data.Get("secret").(string)
+// connectionUsers returns the paths of the roles and static roles that use
+// connection.
+//
+// NOTE: The caller is required to hold b.roleMutex for reading
+func connectionUsers(ctx context.Context, s logical.Storage, connection string) ([]string, error) {
+	var users []string
 
+	roleNames, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range roleNames {
+		entry, err := s.Get(ctx, "role/"+name)
+		if err != nil {
+			return nil, err
+		}
+		var role FaunaRoleEntry
+		if entry == nil {
+			continue
+		}
+		if err := entry.DecodeJSON(&role); err != nil {
+			return nil, err
+		}
+		if role.Connection == connection {
+			users = append(users, "roles/"+name)
+		}
+	}
+
+	staticNames, err := s.List(ctx, "static-role/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range staticNames {
+		role, err := staticRoleRead(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Connection == connection {
+			users = append(users, "static-roles/"+name)
+		}
+	}
+
+	return users, nil
+}
+
+func (b *backend) writeConnection(ctx context.Context, s logical.Storage, connection string, data *framework.FieldData) (*logical.Response, error) {
 	b.clientMutex.Lock()
 	defer b.clientMutex.Unlock()
 
-	entry, err := logical.StorageEntryJSON("config/root", rootConfig{
-		Secret:   secret,
-		Endpoint: endpoint,
-	})
+	config, err := readRootConfig(ctx, s, connection)
 	if err != nil {
 		return nil, err
 	}
+	exists := config != nil
+	if !exists {
+		config = &rootConfig{}
+	}
+
+	// The secret is write-only and may have been rotated since, so keep the
+	// stored one unless a new one is given
+	secret, endpoint := config.Secret, config.Endpoint
+	if secretRaw, ok := data.GetOk("secret"); ok {
+		secret = secretRaw.(string)
+	}
+	if endpointRaw, ok := data.GetOk("endpoint"); ok {
+		endpoint = endpointRaw.(string)
+	}
+	if secret == "" {
+		return logical.ErrorResponse("'secret' is required"), nil
+	}
+
+	if apiVersionRaw, ok := data.GetOk("api_version"); ok {
+		config.APIVersion = apiVersionRaw.(string)
+	}
+	switch config.APIVersion {
+	case "", apiVersion4, apiVersion10:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'api_version' must be %q or %q", apiVersion4, apiVersion10)), nil
+	}
+
+	// Leases remember the connection by name only, so switching its type
+	// would leave them unable to be revoked
+	if typeRaw, ok := data.GetOk("type"); ok {
+		connectionType, currentType := typeRaw.(string), config.Type
+		if connectionType == "" {
+			connectionType = connectionTypeDatabase
+		}
+		if currentType == "" {
+			currentType = connectionTypeDatabase
+		}
+		if exists && connectionType != currentType {
+			return logical.ErrorResponse("'type' can't be changed, delete the connection first"), nil
+		}
+		config.Type = connectionType
+	}
+	switch config.Type {
+	case "", connectionTypeDatabase:
+		if _, ok := data.GetOk("region_group"); ok {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"'region_group' requires 'type' to be %q", connectionTypeAccount)), nil
+		}
+	case connectionTypeAccount:
+		if config.APIVersion != "" && config.APIVersion != apiVersion4 {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"'api_version' does not apply to %q connections", connectionTypeAccount)), nil
+		}
+		if regionGroupRaw, ok := data.GetOk("region_group"); ok {
+			config.RegionGroup = regionGroupRaw.(string)
+		}
+		if config.RegionGroup == "" {
+			config.RegionGroup = defaultRegionGroup
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'type' must be %q or %q", connectionTypeDatabase, connectionTypeAccount)), nil
+	}
+
+	// Catch bad credentials now rather than on the first key request
+	if data.Get("verify_connection").(bool) {
+		verifyConfig := *config
+		verifyConfig.Endpoint = endpoint
+		client, err := verifyConfig.newClient(secret, b.Logger())
+		if err != nil {
+			return nil, err
+		}
+
+		verify := client.verifyKeyAccess
+		if client.isV10() {
+			verify = client.verifyKeyAccessV10
+		} else if client.isAccount() {
+			verify = client.verifyAccountAccess
+		}
+		if err := verify(); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Error verifying connection to Fauna: %s", err)), nil
+		}
+	}
+
+	// A new secret restarts the rotation schedule
+	if secret != config.Secret || config.LastRotated.IsZero() {
+		config.LastRotated = time.Now().UTC()
+	}
+	config.Secret = secret
+	config.Endpoint = endpoint
+
+	if periodRaw, ok := data.GetOk("rotation_period"); ok {
+		config.RotationPeriod = time.Duration(periodRaw.(int)) * time.Second
+	}
+	if config.RotationPeriod < 0 {
+		return logical.ErrorResponse("'rotation_period' must not be negative"), nil
+	}
+	if config.RotationPeriod > 0 && config.APIVersion == apiVersion10 {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"root key rotation requires 'api_version' %q", apiVersion4)), nil
+	}
+	if config.RotationPeriod > 0 && config.Type == connectionTypeAccount {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"root key rotation is not available on %q connections", connectionTypeAccount)), nil
+	}
+
+	if graceRaw, ok := data.GetOk("old_key_grace_period"); ok {
+		config.OldKeyGracePeriod = time.Duration(graceRaw.(int)) * time.Second
+	}
+	if config.OldKeyGracePeriod < 0 {
+		return logical.ErrorResponse("'old_key_grace_period' must not be negative"), nil
+	}
 
-	if err := req.Storage.Put(ctx, entry); err != nil {
+	if err := writeRootConfig(ctx, s, connection, config); err != nil {
 		return nil, err
 	}
 
-	// clear possible cached Fauna clients after successfully updating
-	// config/root
-	b.faunaClient = nil
+	// clear the possibly cached Fauna client after successfully updating the
+	// connection
+	delete(b.faunaClients, connection)
 
 	return nil, nil
 }
@@ -2,7 +2,6 @@ package fauna
 
 import (
 	"context"
-	"reflect"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -17,9 +16,17 @@ func TestBackend_PathConfigRoot(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	ff.addDatabase("child")
+	rootSecret := ff.addKey("", map[string]any{
+		"role":     "admin",
+		"database": &fakeRef{id: "child", coll: &fakeRef{id: "databases"}},
+	})
+
 	configData := map[string]any{
-		"secret":   "fauna-secret",
-		"endpoint": "https://db.fauna.com",
+		"secret":   rootSecret,
+		"endpoint": ff.URL,
 	}
 
 	configReq := &logical.Request{
@@ -43,7 +50,87 @@ func TestBackend_PathConfigRoot(t *testing.T) {
 		t.Fatalf("bad: config reading failed: resp:%#v\n err: %v", resp, err)
 	}
 
-	if !reflect.DeepEqual(resp.Data, configData) {
-		t.Errorf("bad: expected to read config root as %#v, got %#v instead", configData, resp.Data)
+	if _, ok := resp.Data["secret"]; ok {
+		t.Errorf("bad: config root must not return the secret, got %#v", resp.Data)
+	}
+	if _, ok := resp.Data["last_rotated"]; !ok {
+		t.Errorf("bad: expected config root to report last_rotated, got %#v", resp.Data)
+	}
+
+	expected := map[string]any{
+		"endpoint":             ff.URL,
+		"rotation_period":      int64(0),
+		"old_key_grace_period": int64(0),
+		"secret_fingerprint":   secretFingerprint(rootSecret),
+		"hashed_secret":        ff.keyDoc(rootSecret)["hashed_secret"],
+		"key_role":             "admin",
+		"key_database":         "child",
+	}
+	for k, v := range expected {
+		if resp.Data[k] != v {
+			t.Errorf("bad: expected config root %s to be %#v, got %#v", k, v, resp.Data[k])
+		}
+	}
+	for _, k := range []string{"key_ref", "key_created"} {
+		if resp.Data[k] == "" || resp.Data[k] == nil {
+			t.Errorf("bad: expected config root to report %s, got %#v", k, resp.Data)
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Storage:   config.StorageView,
+		Path:      "config/root",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: config deleting failed: resp:%#v\n err: %v", resp, err)
 	}
+	if _, ok := b.faunaClients[""]; ok {
+		t.Error("bad: expected the cached client to be cleared")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   config.StorageView,
+		Path:      "config/root",
+	})
+	if err != nil || resp != nil {
+		t.Fatalf("bad: expected config root to be gone: resp:%#v\n err: %v", resp, err)
+	}
+}
+
+func TestBackend_PathConfigRootVerifyConnection(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b := testBackend(t, s)
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	serverSecret := ff.addKey("", map[string]any{"role": "server"})
+
+	for name, data := range map[string]map[string]any{
+		"bad secret":       {"secret": "not-a-secret", "endpoint": ff.URL},
+		"cannot make keys": {"secret": serverSecret, "endpoint": ff.URL},
+		"unreachable":      {"secret": "account-secret", "endpoint": "http://127.0.0.1:1"},
+		"no secret":        {"endpoint": ff.URL, "verify_connection": false},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "config/root",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+
+	if entry, err := s.Get(context.Background(), "config/root"); err != nil || entry != nil {
+		t.Fatalf("expected nothing to be saved: entry:%#v\n err: %v", entry, err)
+	}
+
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":            "not-a-secret",
+		"endpoint":          ff.URL,
+		"verify_connection": false,
+	})
 }
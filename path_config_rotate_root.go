@@ -2,12 +2,15 @@ package fauna
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	f "github.com/fauna/faunadb-go/v5/faunadb"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
 )
 
 const pathConfigRotateRootHelpSyn = `
@@ -18,10 +21,17 @@ const pathConfigRotateRootHelpDesc = `
 This path attempts to rotate the Fauna root key used by Vault for this mount.
 It is only valid if Vault has been configured with a secret via the config/root
 endpoint.
+
+The new key is created with the same role and data as the current one, in
+the database the current key acts in, and is checked against Fauna before it
+replaces the current key. The old key is deleted once the
+"old_key_grace_period" set on config/root has passed.
 `
 
 const RootKeyName = "vault-root"
 
+var errEmptyRootSecret = errors.New("Cannot call config/rotate-root when secret is empty")
+
 func pathConfigRotateRoot(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config/rotate-root",
@@ -39,60 +49,294 @@ func pathConfigRotateRoot(b *backend) *framework.Path {
 }
 
 func (b *backend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := b.rotateRoot(ctx, req.Storage, ""); err != nil {
+		if err == errEmptyRootSecret {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+// rotateConnections runs rotateRootIfDue for config/root and every named
+// connection. A failing connection does not stop the others from rotating;
+// the first error is returned once all have been tried.
+func (b *backend) rotateConnections(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, connectionConfigPrefix)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, connection := range append([]string{""}, names...) {
+		if err := b.rotateRootIfDue(ctx, s, connection); err != nil {
+			b.Logger().Error("error rotating root key", "connection", connection, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// rotateRootIfDue deletes the previous root key of a connection once its
+// grace period is over, and rotates the root key when automatic rotation is
+// enabled and the rotation period has passed.
+func (b *backend) rotateRootIfDue(ctx context.Context, s logical.Storage, connection string) error {
+	b.clientMutex.RLock()
+	config, err := readRootConfig(ctx, s, connection)
+	b.clientMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	if config.PreviousRef != "" && time.Now().After(config.PreviousExpiresAt) {
+		if err := b.expirePreviousRootKey(ctx, s, connection); err != nil {
+			return err
+		}
+	}
+
+	next := config.nextRotation()
+	if next.IsZero() || time.Now().Before(next) {
+		return nil
+	}
+
+	b.Logger().Info("rotating root key", "connection", connection, "last_rotated", config.LastRotated)
+	return b.rotateRoot(ctx, s, connection)
+}
+
+// expirePreviousRootKey deletes the root key replaced by the last rotation if
+// its grace period is over.
+func (b *backend) expirePreviousRootKey(ctx context.Context, s logical.Storage, connection string) error {
+	client, err := b.client(ctx, s, connection)
+	if err != nil {
+		return err
+	}
+
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	config, err := readRootConfig(ctx, s, connection)
+	if err != nil || config == nil {
+		return err
+	}
+	if config.PreviousRef == "" || time.Now().Before(config.PreviousExpiresAt) {
+		return nil
+	}
+
+	return deletePreviousRootKey(ctx, s, connection, client, config)
+}
+
+// NOTE: The caller is required to hold b.clientMutex for writing
+func deletePreviousRootKey(ctx context.Context, s logical.Storage, connection string, client *FaunaClient, config *rootConfig) error {
+	deleteOld := client.deleteKeyByRef
+	old := config.PreviousRef
+	if config.PreviousSecret != "" {
+		deleteOld, old = client.deleteKeyBySecret, config.PreviousSecret
+	}
+	if err := deleteOld(old); err != nil {
+		return errwrap.Wrapf("error deleting old key: {{err}}", err)
+	}
+
+	// Fauna reports a key it can't find from the client's database the same
+	// way as one that is already gone, so check the old secret stopped
+	// working before forgetting it
+	if config.PreviousSecret != "" {
+		err := client.verifySecret(config.PreviousSecret)
+		if err == nil {
+			return fmt.Errorf("error deleting old key: its secret is still accepted by Fauna")
+		}
+		if _, ok := err.(f.Unauthorized); !ok {
+			return errwrap.Wrapf("error checking old key was deleted: {{err}}", err)
+		}
+	}
+
+	config.PreviousRef = ""
+	config.PreviousSecret = ""
+	config.PreviousExpiresAt = time.Time{}
+	return writeRootConfig(ctx, s, connection, config)
+}
+
+// rotateRoot replaces the root key with a copy of itself. The rotation is
+// tracked by a "root" WAL entry holding the refs of the old and new keys, so
+// a failure part way through is cleaned up by pathConfigRotateRootRollback.
+func (b *backend) rotateRoot(ctx context.Context, s logical.Storage, connection string) error {
 	// have to get the client config first because that takes out a read lock
-	client, err := b.client(ctx, req.Storage)
+	client, err := b.client(ctx, s, connection)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if client == nil {
-		return nil, fmt.Errorf("nil Fauna client")
+		return fmt.Errorf("nil Fauna client")
 	}
 
 	b.clientMutex.Lock()
 	defer b.clientMutex.Unlock()
 
-	rawRootConfig, err := req.Storage.Get(ctx, "config/root")
+	config, err := readRootConfig(ctx, s, connection)
 	if err != nil {
-		return nil, err
-	}
-	if rawRootConfig == nil {
-		return nil, fmt.Errorf("no configuration found for config/root")
+		return err
 	}
-	var config rootConfig
-	if err := rawRootConfig.DecodeJSON(&config); err != nil {
-		return nil, errwrap.Wrapf("error reading root configuration: {{err}}", err)
+	if config == nil {
+		return fmt.Errorf("no configuration found for %s", connectionConfigPath(connection))
 	}
 
 	if config.Secret == "" {
-		return logical.ErrorResponse("Cannot call config/rotate-root when secret is empty"), nil
+		return errEmptyRootSecret
+	}
+
+	if err := client.requireV4("Root key rotation"); err != nil {
+		return err
 	}
 
-	keyName := fmt.Sprintf("vault-root-%d", time.Now().Unix())
-	key, err := client.createKey(&FaunaRoleEntry{
-		Role:  "admin",
-		Extra: map[string]any{"name": keyName},
-	})
+	// The replacement must be able to do exactly what the current key can,
+	// so copy its role and data, where it acts, rather than assuming an
+	// admin key
+	oldKey, err := client.keyFromSecret(config.Secret)
 	if err != nil {
-		return nil, errwrap.Wrapf("error generating new root key: {{err}}", err)
+		return errwrap.Wrapf("error looking up current root key: {{err}}", err)
+	}
+	oldRef, err := oldKey.Ref.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	// A key from an earlier rotation still in its grace period is dropped
+	// now, as only one previous key is tracked
+	if config.PreviousRef != "" {
+		if err := deletePreviousRootKey(ctx, s, connection, client, config); err != nil {
+			return err
+		}
+	}
+
+	walID, err := framework.PutWAL(ctx, s, "root", &walRoot{Connection: connection, OldRef: string(oldRef)})
+	if err != nil {
+		return errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+
+	key, err := client.copyKey(oldKey)
+	if err != nil {
+		if walErr := framework.DeleteWAL(ctx, s, walID); walErr != nil {
+			b.Logger().Warn("error deleting WAL entry", "wal_id", walID, "error", walErr)
+		}
+		return errwrap.Wrapf("error generating new root key: {{err}}", err)
+	}
+	newRef, err := key.Ref.MarshalJSON()
+	if err != nil {
+		return err
 	}
 
-	oldSecret := config.Secret
+	rootWALID, err := framework.PutWAL(ctx, s, "root", &walRoot{Connection: connection, OldRef: string(oldRef), NewRef: string(newRef)})
+	if err != nil {
+		if delErr := client.deleteKey(key.Ref); delErr != nil {
+			b.Logger().Warn("error deleting new root key after WAL failure", "error", delErr)
+		}
+		return errwrap.Wrapf("error writing WAL entry: {{err}}", err)
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return errwrap.Wrapf("error deleting WAL entry: {{err}}", err)
+	}
+
+	if err := client.verifySecret(key.Secret); err != nil {
+		if delErr := client.deleteKey(key.Ref); delErr != nil {
+			b.Logger().Warn("error deleting unusable root key", "error", delErr)
+		} else if walErr := framework.DeleteWAL(ctx, s, rootWALID); walErr != nil {
+			b.Logger().Warn("error deleting WAL entry", "wal_id", rootWALID, "error", walErr)
+		}
+		return errwrap.Wrapf("error verifying new root key: {{err}}", err)
+	}
+
+	// A key scoped to a child database lives in its parent, where the new
+	// key can't name it by ref, so it is found by its secret instead
+	previousSecret := ""
+	if oldKey.Database.ID != "" {
+		previousSecret = config.Secret
+	}
 
+	now := time.Now().UTC()
 	config.Secret = key.Secret
+	config.LastRotated = now
+	config.PreviousRef = string(oldRef)
+	config.PreviousSecret = previousSecret
+	config.PreviousExpiresAt = now.Add(config.OldKeyGracePeriod)
+
+	if err := writeRootConfig(ctx, s, connection, config); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("error saving new %s: {{err}}", connectionConfigPath(connection)), err)
+	}
+
+	delete(b.faunaClients, connection)
+
+	// Without a grace period the old key goes straight away. The old client
+	// is still usable for that since its key has not been deleted yet.
+	if config.OldKeyGracePeriod <= 0 {
+		if err := deletePreviousRootKey(ctx, s, connection, client, config); err != nil {
+			return err
+		}
+	}
 
-	newEntry, err := logical.StorageEntryJSON("config/root", config)
+	if err := framework.DeleteWAL(ctx, s, rootWALID); err != nil {
+		return errwrap.Wrapf("error committing WAL entry: {{err}}", err)
+	}
+
+	return nil
+}
+
+// pathConfigRotateRootRollback undoes a root rotation that failed part way
+// through. If the connection never switched to the new key, the new key is
+// deleted. If it did, the old key is deleted once its grace period is over.
+func (b *backend) pathConfigRotateRootRollback(ctx context.Context, req *logical.Request, _kind string, data any) error {
+	var entry walRoot
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	// Nothing was created before Fauna returned the new key's ref
+	if entry.NewRef == "" {
+		return nil
+	}
+
+	client, err := b.client(ctx, req.Storage, entry.Connection)
 	if err != nil {
-		return nil, errwrap.Wrapf("error generating new config/root JSON: {{err}}", err)
+		return err
 	}
-	if err := req.Storage.Put(ctx, newEntry); err != nil {
-		return nil, errwrap.Wrapf("error saving new config/root: {{err}}", err)
+
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	config, err := readRootConfig(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.Secret == "" {
+		return client.deleteKeyByRef(entry.NewRef)
 	}
 
-	b.faunaClient = nil
+	current, err := client.keyFromSecret(config.Secret)
+	if err != nil {
+		return errwrap.Wrapf("error looking up current root key: {{err}}", err)
+	}
+	currentRef, err := current.Ref.MarshalJSON()
+	if err != nil {
+		return err
+	}
 
-	if err := client.deleteKeyBySecret(oldSecret); err != nil {
-		return nil, errwrap.Wrapf("error deleting old key: {{err}}", err)
+	if string(currentRef) != entry.NewRef {
+		return client.deleteKeyByRef(entry.NewRef)
 	}
 
-	return &logical.Response{}, nil
+	if config.PreviousRef == entry.OldRef && time.Now().After(config.PreviousExpiresAt) {
+		return deletePreviousRootKey(ctx, req.Storage, entry.Connection, client, config)
+	}
+
+	return nil
+}
+
+type walRoot struct {
+	Connection string
+	OldRef     string
+	NewRef     string
 }
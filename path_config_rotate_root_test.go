@@ -0,0 +1,396 @@
+package fauna
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testReadConfigRoot(t *testing.T, b *backend, s logical.Storage) map[string]any {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "config/root",
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: config reading failed: resp:%#v\n err: %v", resp, err)
+	}
+	return resp.Data
+}
+
+func testStoredRootConfig(t *testing.T, s logical.Storage) *rootConfig {
+	t.Helper()
+
+	entry, err := s.Get(context.Background(), "config/root")
+	if err != nil || entry == nil {
+		t.Fatalf("config/root not found: %v", err)
+	}
+	var config rootConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+func TestBackend_RotateRootScheduled(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	rootSecret := ff.addKey("", map[string]any{"role": "admin"})
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":          rootSecret,
+		"endpoint":        ff.URL,
+		"rotation_period": "720h",
+	})
+
+	data := testReadConfigRoot(t, b, s)
+	if data["next_rotation"] == nil {
+		t.Fatalf("expected next_rotation to be reported, got %#v", data)
+	}
+
+	req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if config := testStoredRootConfig(t, s); config.Secret != rootSecret {
+		t.Fatal("expected the root key not to be rotated before the period")
+	}
+
+	config := testStoredRootConfig(t, s)
+	config.LastRotated = config.LastRotated.Add(-721 * time.Hour)
+	entry, err := logical.StorageEntryJSON("config/root", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	rotated := testStoredRootConfig(t, s)
+	if rotated.Secret == rootSecret {
+		t.Fatal("expected the root key to be rotated")
+	}
+	if time.Since(rotated.LastRotated) > time.Minute {
+		t.Errorf("expected last_rotated to be updated, got %s", rotated.LastRotated)
+	}
+	if ff.keyExists(rootSecret) {
+		t.Error("expected the old root key to be deleted")
+	}
+	if !ff.keyExists(rotated.Secret) {
+		t.Error("expected the new root key to exist")
+	}
+
+	// The rotated secret is unknown to operators, so the schedule must be
+	// adjustable without it
+	for _, verify := range []bool{true, false} {
+		testWriteConfigRoot(t, b, s, map[string]any{
+			"rotation_period":   "1440h",
+			"verify_connection": verify,
+		})
+		config := testStoredRootConfig(t, s)
+		if config.Secret != rotated.Secret || config.Endpoint != ff.URL {
+			t.Fatalf("verify_connection=%t: expected the secret and endpoint to be kept, got %#v", verify, config)
+		}
+		if config.RotationPeriod != 1440*time.Hour || !config.LastRotated.Equal(rotated.LastRotated) {
+			t.Fatalf("verify_connection=%t: expected only the rotation period to change, got %#v", verify, config)
+		}
+	}
+}
+
+func TestBackend_RotateRootPreservesKey(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	rootSecret := ff.addKey("", map[string]any{
+		"role": "admin",
+		"data": map[string]any{"owner": "platform"},
+	})
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":   rootSecret,
+		"endpoint": ff.URL,
+	})
+
+	rotate := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root",
+	}
+
+	// A new key that Fauna rejects must not replace the current one
+	ff.failNext("now", 1)
+	if _, err := b.HandleRequest(ctx, rotate); err == nil {
+		t.Fatal("expected rotation to fail when the new key can't be verified")
+	}
+	if config := testStoredRootConfig(t, s); config.Secret != rootSecret {
+		t.Fatal("expected the root key to be unchanged")
+	}
+	if n := ff.count("", "keys"); n != 1 {
+		t.Fatalf("expected the unverified key to be deleted, got %d keys", n)
+	}
+
+	resp, err := b.HandleRequest(ctx, rotate)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: rotation failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	config := testStoredRootConfig(t, s)
+	if config.Secret == rootSecret {
+		t.Fatal("expected the root key to be rotated")
+	}
+	if ff.keyExists(rootSecret) {
+		t.Error("expected the old root key to be deleted")
+	}
+
+	doc := ff.keyDoc(config.Secret)
+	if doc["role"] != "admin" {
+		t.Errorf("expected role to be preserved, got %v", doc["role"])
+	}
+	if data, ok := doc["data"].(map[string]any); !ok || data["owner"] != "platform" {
+		t.Errorf("expected data to be preserved, got %v", doc["data"])
+	}
+}
+
+func TestBackend_RotateRootChildScope(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	ff.addDatabase("child")
+	ff.addDatabase("child/app")
+	rootSecret := ff.addKey("", map[string]any{
+		"role":     "admin",
+		"database": &fakeRef{id: "child", coll: &fakeRef{id: "databases"}},
+		"data":     map[string]any{"owner": "platform"},
+	})
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":   rootSecret,
+		"endpoint": ff.URL,
+	})
+
+	// Deleting the old key must not be taken on trust, as from the child it
+	// may look like it is already gone
+	ff.hideOuterKeys = true
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root",
+	}); err == nil {
+		t.Fatal("expected rotation to fail while the old key can't be deleted")
+	}
+	config := testStoredRootConfig(t, s)
+	if config.Secret == rootSecret || config.PreviousSecret != rootSecret || !ff.keyExists(rootSecret) {
+		t.Fatalf("expected the old root key to be kept for another attempt, got %#v", config)
+	}
+
+	ff.hideOuterKeys = false
+	req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	config = testStoredRootConfig(t, s)
+	if config.PreviousRef != "" || config.PreviousSecret != "" {
+		t.Fatalf("expected the old root key to be forgotten, got %#v", config)
+	}
+	if ff.keyExists(rootSecret) {
+		t.Error("expected the old root key to be deleted")
+	}
+	doc := ff.keyDoc(config.Secret)
+	if doc["__scope"] != "child" || doc["role"] != "admin" {
+		t.Errorf("expected an admin key acting in child, got %#v", doc)
+	}
+	if data, ok := doc["data"].(map[string]any); !ok || data["owner"] != "platform" {
+		t.Errorf("expected data to be preserved, got %v", doc["data"])
+	}
+
+	// The new key manages the same databases the old one did
+	testWriteRole(t, b, s, "app", map[string]any{"role": "server", "database": "app"})
+	resp, err := testReadKey(b, s, "app", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: key creation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if key := ff.keyDoc(resp.Data["secret"].(string)); key["__scope"] != "child/app" {
+		t.Errorf("expected a key for child/app, got %#v", key)
+	}
+}
+
+func TestFaunaClient_CopyKey(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	ff.addDatabase("child")
+	secret := ff.addKey("", map[string]any{
+		"role":     "server",
+		"database": &fakeRef{id: "child", coll: &fakeRef{id: "databases"}},
+	})
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":            secret,
+		"endpoint":          ff.URL,
+		"verify_connection": false,
+	})
+
+	client, err := b.client(ctx, s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := client.keyFromSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := client.copyKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := ff.keyDoc(copied.Secret)
+	if doc["role"] != "server" {
+		t.Errorf("expected role to be copied, got %v", doc["role"])
+	}
+	if doc["__scope"] != "child" || doc["database"] != nil {
+		t.Errorf("expected the copy to live and act in child, got %#v", doc)
+	}
+}
+
+func TestBackend_RotateRootGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+
+	ff := newFakeFauna("account-secret")
+	defer ff.Close()
+	rootSecret := ff.addKey("", map[string]any{"role": "admin"})
+
+	b := testBackend(t, s)
+	testWriteConfigRoot(t, b, s, map[string]any{
+		"secret":               rootSecret,
+		"endpoint":             ff.URL,
+		"old_key_grace_period": "1h",
+	})
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "config/rotate-root",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: rotation failed: resp:%#v\n err: %v", resp, err)
+	}
+	if !ff.keyExists(rootSecret) {
+		t.Fatal("expected the old root key to be kept during the grace period")
+	}
+	if data := testReadConfigRoot(t, b, s); data["previous_key_expires_at"] == nil {
+		t.Fatalf("expected previous_key_expires_at to be reported, got %#v", data)
+	}
+
+	config := testStoredRootConfig(t, s)
+	config.PreviousExpiresAt = time.Now().Add(-time.Minute)
+	if err := writeRootConfig(ctx, s, "", config); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if ff.keyExists(rootSecret) {
+		t.Fatal("expected the old root key to be deleted after the grace period")
+	}
+	if config := testStoredRootConfig(t, s); config.PreviousRef != "" {
+		t.Fatalf("expected the previous key to be forgotten, got %q", config.PreviousRef)
+	}
+}
+
+func TestBackend_RotateRootWAL(t *testing.T) {
+	ctx := context.Background()
+	rotate := func(b *backend, s logical.Storage) error {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "config/rotate-root",
+		})
+		return err
+	}
+
+	t.Run("failure before saving removes the new key", func(t *testing.T) {
+		s := &failingStorage{Storage: &logical.InmemStorage{}}
+		ff := newFakeFauna("account-secret")
+		defer ff.Close()
+		rootSecret := ff.addKey("", map[string]any{"role": "admin"})
+
+		b := testBackend(t, s)
+		testWriteConfigRoot(t, b, s, map[string]any{"secret": rootSecret, "endpoint": ff.URL})
+
+		s.setFailPut("config/root")
+		if err := rotate(b, s); err == nil {
+			t.Fatal("expected rotation to fail")
+		}
+		s.setFailPut()
+		if n := ff.count("", "keys"); n != 2 {
+			t.Fatalf("expected the new key to be left behind, got %d keys", n)
+		}
+
+		testRollbackAll(t, b, s)
+
+		if config := testStoredRootConfig(t, s); config.Secret != rootSecret {
+			t.Fatal("expected the root key to be unchanged")
+		}
+		if !ff.keyExists(rootSecret) {
+			t.Fatal("expected the current root key to be kept")
+		}
+		if n := ff.count("", "keys"); n != 1 {
+			t.Fatalf("expected rollback to delete the new key, got %d keys", n)
+		}
+	})
+
+	t.Run("failure after saving removes the old key", func(t *testing.T) {
+		s := &logical.InmemStorage{}
+		ff := newFakeFauna("account-secret")
+		defer ff.Close()
+		rootSecret := ff.addKey("", map[string]any{"role": "admin"})
+
+		b := testBackend(t, s)
+		testWriteConfigRoot(t, b, s, map[string]any{"secret": rootSecret, "endpoint": ff.URL})
+
+		ff.failNext("delete", 1)
+		if err := rotate(b, s); err == nil {
+			t.Fatal("expected rotation to fail")
+		}
+		config := testStoredRootConfig(t, s)
+		if config.Secret == rootSecret {
+			t.Fatal("expected the new root key to be saved")
+		}
+		if !ff.keyExists(rootSecret) {
+			t.Fatal("expected the old root key to be left behind")
+		}
+
+		testRollbackAll(t, b, s)
+
+		if ff.keyExists(rootSecret) {
+			t.Fatal("expected rollback to delete the old root key")
+		}
+		if !ff.keyExists(config.Secret) {
+			t.Fatal("expected rollback to keep the new root key")
+		}
+	})
+}
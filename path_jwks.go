@@ -0,0 +1,110 @@
+package fauna
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pathJWKSHelpSyn = `
+Public keys that verify the JWTs issued by this mount.
+`
+
+const pathJWKSHelpDesc = `
+This returns the JSON Web Key Set of the current and recently rotated
+signing keys. It requires no authentication, so that Fauna access
+providers can fetch it.
+`
+
+const pathDiscoveryHelpSyn = `
+OpenID Connect discovery document of this mount's JWT issuer.
+`
+
+const pathDiscoveryHelpDesc = `
+This returns the issuer and JWKS URL of the JWTs issued by this mount. It
+requires no authentication.
+`
+
+func pathJWKS(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: jwksPath,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathJWKSRead,
+		},
+
+		HelpSynopsis:    pathJWKSHelpSyn,
+		HelpDescription: pathJWKSHelpDesc,
+	}
+}
+
+func pathDiscovery(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: discoveryPath,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathDiscoveryRead,
+		},
+
+		HelpSynopsis:    pathDiscoveryHelpSyn,
+		HelpDescription: pathDiscoveryHelpDesc,
+	}
+}
+
+func (b *backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.RLock()
+	defer b.jwtMutex.RUnlock()
+
+	keys, err := readJWTKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks, err := keys.jwks()
+	if err != nil {
+		return nil, err
+	}
+	return rawJSONResponse(jwks)
+}
+
+func (b *backend) pathDiscoveryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.jwtMutex.RLock()
+	defer b.jwtMutex.RUnlock()
+
+	config, err := readJWTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.RespondWithStatusCode(
+			logical.ErrorResponse("the JWT issuer is not configured"), req, http.StatusNotFound)
+	}
+
+	return rawJSONResponse(map[string]any{
+		"issuer":                                config.Issuer,
+		"jwks_uri":                              config.jwksURI(),
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwtAlgorithm},
+	})
+}
+
+// rawJSONResponse returns body as is, rather than wrapped in Vault's response
+// envelope, since the consumers of these paths are not Vault clients.
+func rawJSONResponse(body any) (*logical.Response, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			logical.HTTPStatusCode:  http.StatusOK,
+			logical.HTTPRawBody:     raw,
+			logical.HTTPContentType: "application/json",
+		},
+	}, nil
+}
@@ -3,9 +3,12 @@ package fauna
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/mitchellh/mapstructure"
 )
@@ -22,6 +25,16 @@ then "fauna/deploy" would generate access keys for the "deploy" role.
 
 The keys will have a lease associated with them. The keys can be revoked
 by using the lease ID.
+
+"database" picks the database of the key instead of the role's, among the
+role's "allowed_databases".
+
+"metadata" adds string values to the key's data, such as a build ID, if the
+role allows their keys. It can't replace what the role itself puts there.
+
+Roles of type "token" return a Fauna token for a document instead of a key.
+Roles of type "jwt" return a JWT for a Fauna access provider, without a
+lease.
 `
 
 func pathKey(b *backend) *framework.Path {
@@ -34,8 +47,15 @@ func pathKey(b *backend) *framework.Path {
 			},
 			"ttl": {
 				Type:        framework.TypeDurationSecond,
-				Description: "Lifetime of the returned credentials in seconds",
-				Default:     3600,
+				Description: "Lifetime of the returned credentials in seconds. Defaults to the configured lease and is capped by the max lease.",
+			},
+			"database": {
+				Type:        framework.TypeString,
+				Description: `Database to issue the credentials for instead of the role's, if the role's "allowed_databases" matches it.`,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: `Data to add to the key, limited to the keys the role's "allowed_metadata_keys" and "denied_metadata_keys" permit.`,
 			},
 		},
 
@@ -62,7 +82,85 @@ func (b *backend) pathRead(ctx context.Context, req *logical.Request, d *framewo
 			"Role '%s' not found", roleName)), nil
 	}
 
-	return b.faunaKeyCreate(ctx, req.Storage, req.DisplayName, roleName, role)
+	var ttl time.Duration
+	if ttlRaw, ok := d.GetOk("ttl"); ok {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+	}
+	if ttl < 0 {
+		return logical.ErrorResponse("ttl must not be negative"), nil
+	}
+
+	if databaseRaw, ok := d.GetOk("database"); ok {
+		database := strings.Trim(databaseRaw.(string), "/")
+		if !validDatabasePath(database) {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"invalid database path %q", database)), nil
+		}
+		allowed, err := b.databaseAllowed(req, roleName, role, database)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"Database %q is not allowed by role '%s'", database, roleName)), nil
+		}
+
+		picked := *role
+		picked.Database = database
+		role = &picked
+	}
+
+	metadata := d.Get("metadata").(map[string]string)
+	if len(metadata) > 0 && (role.Type == roleTypeToken || role.Type == roleTypeJWT) {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"Role '%s' of type %q does not take metadata", roleName, role.Type)), nil
+	}
+
+	switch role.Type {
+	case roleTypeToken:
+		return b.faunaTokenCreate(ctx, req, roleName, role, ttl)
+	case roleTypeJWT:
+		return b.jwtCreate(ctx, req, roleName, role, ttl)
+	}
+
+	return b.faunaKeyCreate(ctx, req, roleName, role, metadata, ttl)
+}
+
+// databaseAllowed reports whether the requester may pick database for role.
+// Entries of the role's allowed databases that refer to the caller's identity
+// only match if they can be rendered for it, and never let an identity value
+// add a glob or a level of nesting.
+func (b *backend) databaseAllowed(req *logical.Request, roleName string, role *FaunaRoleEntry, database string) (bool, error) {
+	if role.Type == roleTypeJWT || len(role.AllowedDatabases) == 0 {
+		return false, nil
+	}
+
+	var templates []string
+	if req.EntityID != "" {
+		templates = role.AllowedDatabases
+	}
+	in, err := b.templateInput(req, roleName, templates)
+	if err != nil {
+		return false, err
+	}
+	in.CheckIdentity = func(value string) error {
+		if strings.ContainsAny(value, "*/") {
+			return fmt.Errorf("value %q can't be part of a database path", value)
+		}
+		return nil
+	}
+
+	for _, entry := range role.AllowedDatabases {
+		allowed, err := renderTemplate(entry, in)
+		if err != nil {
+			b.Logger().Debug("skipping allowed database", "role", roleName, "entry", entry, "error", err)
+			continue
+		}
+		if strutil.GlobbedStringsMatch(strings.Trim(allowed, "/"), database) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (b *backend) pathKeyRollback(ctx context.Context, req *logical.Request, _kind string, data any) error {
@@ -71,25 +169,66 @@ func (b *backend) pathKeyRollback(ctx context.Context, req *logical.Request, _ki
 		return err
 	}
 
-	// Get the client
-	client, err := b.client(ctx, req.Storage)
-	if err != nil {
-		return err
+	// Entries written before Fauna returned a ref have nothing to clean up
+	if entry.Ref == "" && entry.Database == "" && entry.FaunaRole == "" && entry.AccountDatabase == "" {
+		return nil
 	}
 
-	ref, err := client.strToRef(entry.Ref)
+	// Get the client of the connection the key was created with. Leases and
+	// WAL entries from before named connections use config/root.
+	client, err := b.client(ctx, req.Storage, entry.Connection)
 	if err != nil {
 		return err
 	}
 
-	err = client.deleteKey(*ref)
-	if err != nil {
-		return err
+	// Top-level databases created through the Account API take their keys
+	// with them
+	if entry.AccountDatabase != "" {
+		return client.deleteAccountDatabase(entry.AccountDatabase)
+	}
+
+	// Refs of keys and databases created for roles of type "database" are
+	// relative to the role's parent database
+	client = client.scoped(entry.Parent)
+
+	// Keys issued over the v10 API are tracked by ID. Entries without an API
+	// version predate it and were issued over v4.
+	if entry.APIVersion == apiVersion10 {
+		return client.deleteKeyV10(entry.Ref)
+	}
+
+	if entry.Ref != "" {
+		if err := client.deleteKeyByRef(entry.Ref); err != nil {
+			return err
+		}
+	}
+
+	if entry.FaunaRole != "" {
+		if err := client.deleteRoleByName(entry.FaunaRole); err != nil {
+			return err
+		}
+	}
+
+	if entry.Database != "" {
+		return client.deleteDatabaseByRef(entry.Database)
 	}
 
 	return nil
 }
 
+// walKey is both the "key" WAL entry and the internal data of key and token
+// leases. Ref is the key or token, and like Database and FaunaRole is
+// relative to Parent. FaunaRole is the name of a role created for the key.
+// APIVersion is the Fauna API the key was issued over; for "10", Ref is the
+// key's ID rather than a JSON encoded v4 ref. AccountDatabase is the path of
+// a top-level database created through the Account API, in which case Ref is
+// the ID of its key.
 type walKey struct {
-	Ref string
+	Connection      string
+	Ref             string
+	Parent          string
+	Database        string
+	FaunaRole       string `json:"fauna_role" mapstructure:"fauna_role"`
+	APIVersion      string `json:"api_version" mapstructure:"api_version"`
+	AccountDatabase string `json:"account_database" mapstructure:"account_database"`
 }
@@ -0,0 +1,68 @@
+package fauna
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pathStaticCredsHelpSyn = `
+Read the current Fauna key of a static role.
+`
+
+const pathStaticCredsHelpDesc = `
+This path returns the secret of the Fauna key Vault currently manages for a
+static role. The key is not leased; it stays valid until Vault rotates it.
+The "ttl" in the response is the number of seconds until the next rotation.
+`
+
+func pathStaticCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-creds/" + framework.GenericNameWithAtRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the static role",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathStaticCredsRead,
+		},
+
+		HelpSynopsis:    pathStaticCredsHelpSyn,
+		HelpDescription: pathStaticCredsHelpDesc,
+	}
+}
+
+func (b *backend) pathStaticCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+
+	entry, err := staticRoleRead(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("Static role '%s' not found", roleName), nil
+	}
+
+	nextRotation := entry.LastRotated.Add(entry.RotationPeriod)
+	ttl := time.Until(nextRotation)
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return &logical.Response{
+		Data: map[string]any{
+			"secret":        entry.Secret,
+			"last_rotated":  entry.LastRotated.Format(time.RFC3339),
+			"next_rotation": nextRotation.Format(time.RFC3339),
+			"ttl":           int64(ttl.Seconds()),
+		},
+	}, nil
+}
@@ -0,0 +1,434 @@
+package fauna
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const pathListStaticRolesHelpSyn = `List the existing static roles in this backend`
+
+const pathListStaticRolesHelpDesc = `Static roles will be listed by the role name.`
+
+const pathStaticRolesHelpSyn = `
+Manage static roles whose Fauna key is owned and rotated by Vault.
+`
+
+const pathStaticRolesHelpDesc = `
+This path allows you to read and write static roles. Each static role has a
+single Fauna key that Vault creates when the role is written and replaces
+every "rotation_period". The current secret can be read from the
+"static-creds/" endpoint.
+
+After a rotation the previous key stays valid for "overlap_period" so that
+clients holding it have time to pick up the new secret. Changing "role",
+"database" or "extra" rotates the key straight away. Deleting a static role
+deletes its keys from Fauna.
+`
+
+const minStaticRotationPeriod = time.Minute
+
+func pathListStaticRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-roles/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathStaticRoleList,
+		},
+
+		HelpSynopsis:    pathListStaticRolesHelpSyn,
+		HelpDescription: pathListStaticRolesHelpDesc,
+	}
+}
+
+func pathStaticRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-roles/" + framework.GenericNameWithAtRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the static role",
+			},
+
+			"role": {
+				Type:        framework.TypeString,
+				Description: `Fauna role to associate with the key.`,
+			},
+
+			"database": {
+				Type:        framework.TypeString,
+				Description: `Path of the database associated with this key, e.g. "org/team/app" for a nested database.`,
+			},
+
+			"extra": {
+				Type:        framework.TypeMap,
+				Description: `map of data to add to the generated key`,
+			},
+
+			"rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How often Vault replaces the key. Must be at least one minute.`,
+			},
+
+			"overlap_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: `How long the previous key stays valid after a rotation. Must be shorter than rotation_period.`,
+			},
+
+			"connection": {
+				Type:        framework.TypeString,
+				Description: `Name of the connection under config/connections to create keys with. Defaults to config/root. Cannot be changed once the role exists.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.DeleteOperation: b.pathStaticRolesDelete,
+			logical.ReadOperation:   b.pathStaticRolesRead,
+			logical.UpdateOperation: b.pathStaticRolesWrite,
+		},
+
+		HelpSynopsis:    pathStaticRolesHelpSyn,
+		HelpDescription: pathStaticRolesHelpDesc,
+	}
+}
+
+func (b *backend) pathStaticRoleList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+	entries, err := req.Storage.List(ctx, "static-role/")
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathStaticRolesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.roleMutex.RLock()
+	defer b.roleMutex.RUnlock()
+
+	entry, err := staticRoleRead(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: entry.toResponseData(),
+	}, nil
+}
+
+func (b *backend) pathStaticRolesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+	if roleName == "" {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+	entry, err := staticRoleRead(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	isCreate := entry == nil
+	if isCreate {
+		entry = &staticRoleEntry{}
+	}
+	previous := *entry
+
+	if roleRaw, ok := d.GetOk("role"); ok {
+		entry.Role = roleRaw.(string)
+	}
+
+	if databaseRaw, ok := d.GetOk("database"); ok {
+		entry.Database = strings.Trim(databaseRaw.(string), "/")
+	}
+	if !validDatabasePath(entry.Database) {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"invalid database path %q", entry.Database)), nil
+	}
+
+	if extraRaw, ok := d.GetOk("extra"); ok {
+		entry.Extra = extraRaw.(map[string]any)
+	}
+
+	if periodRaw, ok := d.GetOk("rotation_period"); ok {
+		entry.RotationPeriod = time.Duration(periodRaw.(int)) * time.Second
+	}
+
+	if overlapRaw, ok := d.GetOk("overlap_period"); ok {
+		entry.OverlapPeriod = time.Duration(overlapRaw.(int)) * time.Second
+	}
+
+	// The role's keys live on its connection, so moving it would strand them
+	if connectionRaw, ok := d.GetOk("connection"); ok {
+		if !isCreate && connectionRaw.(string) != entry.Connection {
+			return logical.ErrorResponse("'connection' cannot be changed on an existing static role"), nil
+		}
+		entry.Connection = connectionRaw.(string)
+	}
+	if errResp, err := b.checkConnection(ctx, req.Storage, entry.Connection); errResp != nil || err != nil {
+		return errResp, err
+	}
+	client, err := b.client(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := client.requireV4("Static roles"); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if entry.Role == "" {
+		return logical.ErrorResponse("'role' is a required parameter"), nil
+	}
+	if entry.RotationPeriod < minStaticRotationPeriod {
+		return logical.ErrorResponse(fmt.Sprintf(
+			"'rotation_period' must be at least %s", minStaticRotationPeriod)), nil
+	}
+	if entry.OverlapPeriod < 0 || entry.OverlapPeriod >= entry.RotationPeriod {
+		return logical.ErrorResponse("'overlap_period' must be shorter than 'rotation_period'"), nil
+	}
+
+	// New roles get their first key straight away, as do roles whose key
+	// changes. Others keep their key until the next scheduled rotation.
+	if isCreate || entry.Role != previous.Role || entry.Database != previous.Database ||
+		!reflect.DeepEqual(entry.Extra, previous.Extra) {
+		if err := b.rotateStaticRole(ctx, req.Storage, roleName, entry); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if err := setStaticRole(ctx, req.Storage, roleName, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathStaticRolesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName := d.Get("name").(string)
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+	entry, err := staticRoleRead(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	client, err := b.client(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range [][2]string{{entry.Parent, entry.Ref}, {entry.PreviousParent, entry.PreviousRef}} {
+		if key[1] == "" {
+			continue
+		}
+		if err := client.scoped(key[0]).deleteKeyByRef(key[1]); err != nil {
+			return nil, errwrap.Wrapf("error deleting static role key: {{err}}", err)
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, "static-role/"+roleName); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// rotateStaticRole replaces the key of a static role and persists the entry.
+// The replaced key is kept until the role's overlap period has passed; any
+// key still waiting from an earlier rotation is deleted first.
+//
+// NOTE: The caller is required to hold b.roleMutex for writing
+func (b *backend) rotateStaticRole(ctx context.Context, s logical.Storage, roleName string, entry *staticRoleEntry) error {
+	client, err := b.client(ctx, s, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	if entry.PreviousRef != "" {
+		if err := client.scoped(entry.PreviousParent).deleteKeyByRef(entry.PreviousRef); err != nil {
+			return errwrap.Wrapf("error deleting previous static role key: {{err}}", err)
+		}
+		entry.PreviousRef = ""
+		entry.PreviousParent = ""
+		entry.PreviousExpiresAt = time.Time{}
+	}
+
+	faunaKey, keyWAL, walID, err := b.createKeyWithWAL(ctx, s, client, entry.faunaRole(), time.Time{})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	oldRef, oldParent := entry.Ref, entry.Parent
+	entry.Secret = faunaKey.Secret
+	entry.Ref = keyWAL.Ref
+	entry.Parent = keyWAL.Parent
+	entry.LastRotated = now
+	if oldRef != "" && entry.OverlapPeriod > 0 {
+		entry.PreviousRef = oldRef
+		entry.PreviousParent = oldParent
+		entry.PreviousExpiresAt = now.Add(entry.OverlapPeriod)
+	}
+
+	if err := setStaticRole(ctx, s, roleName, entry); err != nil {
+		return err
+	}
+
+	// The new key is recorded in storage, so commit it by removing the WAL
+	// entry.
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return errwrap.Wrapf("error committing WAL entry: {{err}}", err)
+	}
+
+	if oldRef != "" && entry.OverlapPeriod == 0 {
+		if err := client.scoped(oldParent).deleteKeyByRef(oldRef); err != nil {
+			return errwrap.Wrapf("error deleting previous static role key: {{err}}", err)
+		}
+	}
+
+	return nil
+}
+
+// rotateStaticRoles rotates every static role that is due and deletes
+// previous keys whose overlap period has ended. Failures are logged and the
+// remaining roles are still processed.
+func (b *backend) rotateStaticRoles(ctx context.Context, s logical.Storage) error {
+	roleNames, err := s.List(ctx, "static-role/")
+	if err != nil {
+		return err
+	}
+
+	for _, roleName := range roleNames {
+		if err := b.rotateStaticRoleIfDue(ctx, s, roleName); err != nil {
+			b.Logger().Error("error rotating static role", "role", roleName, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) rotateStaticRoleIfDue(ctx context.Context, s logical.Storage, roleName string) error {
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	entry, err := staticRoleRead(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if now.After(entry.LastRotated.Add(entry.RotationPeriod)) {
+		return b.rotateStaticRole(ctx, s, roleName, entry)
+	}
+
+	if entry.PreviousRef != "" && now.After(entry.PreviousExpiresAt) {
+		client, err := b.client(ctx, s, entry.Connection)
+		if err != nil {
+			return err
+		}
+		if err := client.scoped(entry.PreviousParent).deleteKeyByRef(entry.PreviousRef); err != nil {
+			return errwrap.Wrapf("error deleting previous static role key: {{err}}", err)
+		}
+		entry.PreviousRef = ""
+		entry.PreviousParent = ""
+		entry.PreviousExpiresAt = time.Time{}
+		return setStaticRole(ctx, s, roleName, entry)
+	}
+
+	return nil
+}
+
+func staticRoleRead(ctx context.Context, s logical.Storage, roleName string) (*staticRoleEntry, error) {
+	if roleName == "" {
+		return nil, fmt.Errorf("missing role name")
+	}
+	entry, err := s.Get(ctx, "static-role/"+roleName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var roleEntry staticRoleEntry
+	if err := entry.DecodeJSON(&roleEntry); err != nil {
+		return nil, err
+	}
+	return &roleEntry, nil
+}
+
+func setStaticRole(ctx context.Context, s logical.Storage, roleName string, roleEntry *staticRoleEntry) error {
+	if roleName == "" {
+		return fmt.Errorf("empty role name")
+	}
+	if roleEntry == nil {
+		return fmt.Errorf("nil roleEntry")
+	}
+	entry, err := logical.StorageEntryJSON("static-role/"+roleName, roleEntry)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("nil result when writing to storage")
+	}
+	return s.Put(ctx, entry)
+}
+
+type staticRoleEntry struct {
+	Role              string         `json:"role"`                // Fauna role to associated with the key.
+	Database          string         `json:"database"`            // Path of the Fauna database associated with the key, e.g. "org/team/app".
+	Extra             map[string]any `json:"extra"`               // JSON-serialized inline extra data to add to the key.
+	RotationPeriod    time.Duration  `json:"rotation_period"`     // How often the key is replaced.
+	OverlapPeriod     time.Duration  `json:"overlap_period"`      // How long the replaced key stays valid.
+	Secret            string         `json:"secret"`              // Secret of the current key.
+	Ref               string         `json:"ref"`                 // JSON encoded ref of the current key.
+	Parent            string         `json:"parent"`              // Path of the database the current key was created in, which Ref is relative to.
+	LastRotated       time.Time      `json:"last_rotated"`        // When the current key was created.
+	PreviousRef       string         `json:"previous_ref"`        // JSON encoded ref of the replaced key, if still valid.
+	PreviousParent    string         `json:"previous_parent"`     // Path of the database PreviousRef is relative to.
+	PreviousExpiresAt time.Time      `json:"previous_expires_at"` // When the replaced key gets deleted.
+	Connection        string         `json:"connection"`          // Named connection the keys live on, "" for config/root.
+}
+
+// faunaRole returns the key settings of the static role in the form used to
+// create dynamic keys.
+func (r *staticRoleEntry) faunaRole() *FaunaRoleEntry {
+	return &FaunaRoleEntry{
+		Role:       r.Role,
+		Database:   r.Database,
+		Extra:      r.Extra,
+		Connection: r.Connection,
+	}
+}
+
+func (r *staticRoleEntry) toResponseData() map[string]any {
+	respData := map[string]any{
+		"role":            r.Role,
+		"database":        r.Database,
+		"extra":           r.Extra,
+		"rotation_period": int64(r.RotationPeriod.Seconds()),
+		"overlap_period":  int64(r.OverlapPeriod.Seconds()),
+		"last_rotated":    r.LastRotated.Format(time.RFC3339),
+		"connection":      r.Connection,
+	}
+
+	return respData
+}
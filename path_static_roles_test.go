@@ -0,0 +1,162 @@
+package fauna
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testReadStaticCreds(t *testing.T, b *backend, s logical.Storage, name string) string {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Storage:   s,
+		Path:      "static-creds/" + name,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: static creds reading failed: resp:%#v\n err: %v", resp, err)
+	}
+	return resp.Data["secret"].(string)
+}
+
+// testAgeStaticRole moves the rotation bookkeeping of a static role into the
+// past, as if the given duration had passed.
+func testAgeStaticRole(t *testing.T, s logical.Storage, name string, by time.Duration) {
+	t.Helper()
+
+	ctx := context.Background()
+	entry, err := staticRoleRead(ctx, s, name)
+	if err != nil || entry == nil {
+		t.Fatalf("static role %q not found: %v", name, err)
+	}
+	entry.LastRotated = entry.LastRotated.Add(-by)
+	if !entry.PreviousExpiresAt.IsZero() {
+		entry.PreviousExpiresAt = entry.PreviousExpiresAt.Add(-by)
+	}
+	if err := setStaticRole(ctx, s, name, entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackend_StaticRoles(t *testing.T) {
+	ctx := context.Background()
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+	req := &logical.Request{Operation: logical.RollbackOperation, Storage: s}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Storage:   s,
+		Path:      "static-roles/legacy",
+		Data: map[string]any{
+			"role":            "server",
+			"rotation_period": "1h",
+			"overlap_period":  "10m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: static role writing failed: resp:%#v\n err: %v", resp, err)
+	}
+
+	first := testReadStaticCreds(t, b, s, "legacy")
+	if first == "" {
+		t.Fatal("expected a secret for the static role")
+	}
+	if n := ff.count("", "keys"); n != 1 {
+		t.Fatalf("expected 1 key in Fauna, got %d", n)
+	}
+
+	// Nothing is due yet
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if secret := testReadStaticCreds(t, b, s, "legacy"); secret != first {
+		t.Fatal("expected the secret to be unchanged before the rotation period")
+	}
+
+	testAgeStaticRole(t, s, "legacy", 61*time.Minute)
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	second := testReadStaticCreds(t, b, s, "legacy")
+	if second == first {
+		t.Fatal("expected the secret to be rotated")
+	}
+	if n := ff.count("", "keys"); n != 2 {
+		t.Fatalf("expected the previous key to be kept during the overlap, got %d keys", n)
+	}
+
+	testAgeStaticRole(t, s, "legacy", 11*time.Minute)
+	if err := b.periodicFunc(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if secret := testReadStaticCreds(t, b, s, "legacy"); secret != second {
+		t.Fatal("expected the secret to be unchanged after the overlap")
+	}
+	if n := ff.count("", "keys"); n != 1 {
+		t.Fatalf("expected the previous key to be deleted after the overlap, got %d keys", n)
+	}
+
+	// Changing what the key can do replaces it straight away, while other
+	// changes wait for the next rotation
+	for _, data := range []map[string]any{{"overlap_period": "0s"}, {"role": "read-only"}} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "static-roles/legacy",
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: static role updating failed: resp:%#v\n err: %v", resp, err)
+		}
+	}
+	third := testReadStaticCreds(t, b, s, "legacy")
+	if third == second {
+		t.Fatal("expected the secret to be rotated when the role changed")
+	}
+	if key := ff.keyDoc(third); key == nil || key["role"] != "read-only" {
+		t.Fatalf("expected a read-only key, got %#v", key)
+	}
+	if ff.keyExists(second) {
+		t.Fatal("expected the key with the old role to be deleted")
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Storage:   s,
+		Path:      "static-roles/legacy",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: static role deleting failed: resp:%#v\n err: %v", resp, err)
+	}
+	if n := ff.count("", "keys"); n != 0 {
+		t.Fatalf("expected the static role keys to be deleted, got %d keys", n)
+	}
+}
+
+func TestBackend_StaticRolesValidation(t *testing.T) {
+	s := &logical.InmemStorage{}
+	b, ff := testBackendWithFauna(t, s)
+
+	for name, data := range map[string]map[string]any{
+		"missing role":     {"rotation_period": "1h"},
+		"short rotation":   {"role": "server", "rotation_period": "10s"},
+		"overlap too long": {"role": "server", "rotation_period": "1h", "overlap_period": "1h"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Storage:   s,
+			Path:      "static-roles/bad",
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error response: resp:%#v\n err: %v", name, resp, err)
+		}
+	}
+	if n := ff.count("", "keys"); n != 0 {
+		t.Fatalf("expected no keys in Fauna, got %d", n)
+	}
+}
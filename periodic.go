@@ -0,0 +1,30 @@
+package fauna
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	// Rotations write to Fauna and to storage, so only run them where the
+	// mount's data is writable
+	stateFlags := consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby
+	if !b.System().LocalMount() && b.System().ReplicationState().HasState(stateFlags) {
+		return nil
+	}
+
+	// rotateConnections logs its own failures
+	rootErr := b.rotateConnections(ctx, req.Storage)
+
+	if err := b.rotateStaticRoles(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	if err := b.rotateJWTKeyIfDue(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	return rootErr
+}
@@ -11,7 +11,8 @@ import (
 
 func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data any) error {
 	walRollbackMap := map[string]framework.WALRollbackFunc{
-		"key": b.pathKeyRollback,
+		"key":  b.pathKeyRollback,
+		"root": b.pathConfigRotateRootRollback,
 	}
 
 	stateFlags := consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby
@@ -0,0 +1,196 @@
+package fauna
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/helper/template"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// identityDirective matches the start of an action holding a Vault identity
+// template directive, such as {{identity.entity.metadata.team}}, up to the
+// end of the directive. The rest of the action may pipe it to functions.
+var identityDirective = regexp.MustCompile(`(\{\{-?\s*)(identity\.[^\s{}|]+)`)
+
+// templateInput is what name and extra templates are rendered with. Entity
+// and Groups are only needed by templates with identity directives.
+type templateInput struct {
+	RoleName    string
+	DisplayName string
+	Entity      *logical.Entity
+	Groups      []*logical.Group
+
+	// CheckIdentity, if set, vets every value an identity directive
+	// resolves to before it is used.
+	CheckIdentity func(value string) error
+}
+
+// templateInput returns what templates are rendered with for req. The
+// caller's entity and groups are looked up if any of templates needs them.
+func (b *backend) templateInput(req *logical.Request, roleName string, templates []string) (templateInput, error) {
+	in := templateInput{RoleName: roleName, DisplayName: req.DisplayName}
+
+	for _, rawTemplate := range templates {
+		if !usesIdentity(rawTemplate) {
+			continue
+		}
+		if req.EntityID == "" {
+			return in, fmt.Errorf("Role '%s' has templates that need the caller's entity and the request has none", roleName)
+		}
+		entity, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return in, errwrap.Wrapf("error looking up entity: {{err}}", err)
+		}
+		if entity == nil {
+			return in, fmt.Errorf("Entity '%s' not found", req.EntityID)
+		}
+		groups, err := b.System().GroupsForEntity(req.EntityID)
+		if err != nil {
+			return in, errwrap.Wrapf("error looking up groups: {{err}}", err)
+		}
+		in.Entity, in.Groups = entity, groups
+		break
+	}
+
+	return in, nil
+}
+
+// usesIdentity reports whether a template refers to the caller's identity.
+func usesIdentity(rawTemplate string) bool {
+	return identityDirective.MatchString(rawTemplate)
+}
+
+// parseTemplate parses a template in the syntax of Vault's username
+// templates, which may also hold identity directives. The directives are
+// turned into lookups of their values, so that whatever they resolve to is
+// never parsed as a template itself. The directives are returned in the
+// order of those lookups.
+func parseTemplate(rawTemplate string) (template.StringTemplate, []string, error) {
+	var directives []string
+	rewritten := identityDirective.ReplaceAllStringFunc(rawTemplate, func(match string) string {
+		submatches := identityDirective.FindStringSubmatch(match)
+		directives = append(directives, submatches[2])
+		return fmt.Sprintf("%sindex .Identity %d", submatches[1], len(directives)-1)
+	})
+
+	tmpl, err := template.NewTemplate(template.Template(rewritten))
+	if err != nil {
+		return template.StringTemplate{}, nil, err
+	}
+	return tmpl, directives, nil
+}
+
+// validateTemplate checks that a template can be rendered, short of the
+// caller's identity.
+func validateTemplate(rawTemplate string) error {
+	_, directives, err := parseTemplate(rawTemplate)
+	if err != nil {
+		return err
+	}
+	for _, directive := range directives {
+		if !strings.HasPrefix(directive, "identity.entity.") && !strings.HasPrefix(directive, "identity.groups.") {
+			return fmt.Errorf("unknown identity directive %q", directive)
+		}
+	}
+	return nil
+}
+
+// renderTemplate renders a template for a request. It has .RoleName,
+// .DisplayName and identity directives, which need in.Entity.
+func renderTemplate(rawTemplate string, in templateInput) (string, error) {
+	tmpl, directives, err := parseTemplate(rawTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	identity := make([]string, len(directives))
+	for i, directive := range directives {
+		_, value, err := identitytpl.PopulateString(identitytpl.PopulateStringInput{
+			String: "{{" + directive + "}}",
+			Entity: in.Entity,
+			Groups: in.Groups,
+			Mode:   identitytpl.ACLTemplating,
+		})
+		if err == nil && in.CheckIdentity != nil {
+			err = in.CheckIdentity(value)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", directive, err)
+		}
+		identity[i] = value
+	}
+
+	return tmpl.Generate(map[string]any{
+		"RoleName":    in.RoleName,
+		"DisplayName": in.DisplayName,
+		"Identity":    identity,
+	})
+}
+
+// renderExtra returns a copy of extra with every string value that holds a
+// template rendered, including those in nested objects and arrays.
+func renderExtra(extra map[string]any, in templateInput) (map[string]any, error) {
+	rendered, err := renderExtraValue(extra, in)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]any), nil
+}
+
+func renderExtraValue(v any, in templateInput) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		return renderTemplate(v, in)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			rendered, err := renderExtraValue(item, in)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = rendered
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			rendered, err := renderExtraValue(item, in)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// extraTemplates returns every string value in extra that holds a template.
+func extraTemplates(v any) []string {
+	switch v := v.(type) {
+	case string:
+		if strings.Contains(v, "{{") {
+			return []string{v}
+		}
+	case map[string]any:
+		var out []string
+		for _, item := range v {
+			out = append(out, extraTemplates(item)...)
+		}
+		return out
+	case []any:
+		var out []string
+		for _, item := range v {
+			out = append(out, extraTemplates(item)...)
+		}
+		return out
+	}
+	return nil
+}